@@ -1,168 +1,380 @@
 package cmds
 
 import (
-	"fmt"
-	"net"
 	"strings"
-	"time"
 
 	"github.com/codecrafters-io/redis-starter-go/app/handlers"
 )
 
-var redisKeyValueStore = make(map[string]interface{})
-var redisKeyExpiryTime = make(map[string]time.Time)
+// RunCmds executes one command. The caller must hold the keyspace lock, and
+// keep holding it while it propagates the command, so that replicas see
+// writes in the order they ran.
+func RunCmds(c *handlers.Client, cmdParser [][]byte) {
+	w := c.W
 
-var redisKeyTypeStore = make(map[string]string)
-
-func RunCmds(conn net.Conn, cmdParser []interface{}) {
-
-	fmt.Println("inside run cmds")
-	switch strings.ToUpper(fmt.Sprintf("%v", cmdParser[0])) {
+	switch strings.ToUpper(string(cmdParser[0])) {
 	case "PING":
-		conn.Write([]byte("+PONG\r\n"))
+		w.WriteSimpleString("PONG")
 
 	case "ECHO":
 		if len(cmdParser) > 1 {
-			conn.Write([]byte("+" + fmt.Sprintf("%v", cmdParser[1]) + "\r\n"))
+			w.WriteBulk(cmdParser[1])
 		} else {
-			conn.Write([]byte("+\r\n"))
+			w.WriteError("ERR wrong number of arguments for 'echo' command")
 		}
 
 	case "SET":
-		if len(cmdParser) < 2 {
-			conn.Write([]byte("-ERR wrong number of arguments\r\n"))
+		handlers.SET(c, cmdParser[1:])
 
+	case "GET":
+		if len(cmdParser) < 2 {
+			w.WriteError("ERR wrong number of arguments for 'get' command")
+			break
 		}
 
-		for i := 0; i < len(cmdParser); i += 3 {
-			if i+2 < len(cmdParser) {
+		handlers.GET(c, cmdParser[1:])
 
-				key := fmt.Sprintf("%v", cmdParser[i])
+	case "APPEND":
+		handlers.APPEND(c, cmdParser[1:])
 
-				redisKeyTypeStore[key] = "string"
-				handlers.SET(cmdParser[i:i+3], conn)
-			}
-		}
+	case "STRLEN":
+		handlers.STRLEN(c, cmdParser[1:])
 
-	case "GET":
-		if len(cmdParser) < 2 {
-			conn.Write([]byte("-ERR wrong number of arguments\r\n"))
-		}
+	case "GETRANGE":
+		handlers.GETRANGE(c, cmdParser[1:])
+
+	case "SETRANGE":
+		handlers.SETRANGE(c, cmdParser[1:])
+
+	case "GETSET":
+		handlers.GETSET(c, cmdParser[1:])
+
+	case "GETDEL":
+		handlers.GETDEL(c, cmdParser[1:])
+
+	case "GETEX":
+		handlers.GETEX(c, cmdParser[1:])
+
+	case "SETNX":
+		handlers.SETNX(c, cmdParser[1:])
+
+	case "SETEX":
+		handlers.SETEX(c, cmdParser[1:])
+
+	case "PSETEX":
+		handlers.PSETEX(c, cmdParser[1:])
+
+	case "MGET":
+		handlers.MGET(c, cmdParser[1:])
+
+	case "MSET":
+		handlers.MSET(c, cmdParser[1:])
+
+	case "MSETNX":
+		handlers.MSETNX(c, cmdParser[1:])
+
+	case "SETBIT":
+		handlers.SETBIT(c, cmdParser[1:])
+
+	case "GETBIT":
+		handlers.GETBIT(c, cmdParser[1:])
+
+	case "BITCOUNT":
+		handlers.BITCOUNT(c, cmdParser[1:])
+
+	case "BITPOS":
+		handlers.BITPOS(c, cmdParser[1:])
+
+	case "BITOP":
+		handlers.BITOP(c, cmdParser[1:])
+
+	case "BITFIELD":
+		handlers.BITFIELD(c, cmdParser[1:])
+
+	case "BITFIELD_RO":
+		handlers.BITFIELD_RO(c, cmdParser[1:])
+
+	case "PFADD":
+		handlers.PFADD(c, cmdParser[1:])
+
+	case "PFCOUNT":
+		handlers.PFCOUNT(c, cmdParser[1:])
+
+	case "PFMERGE":
+		handlers.PFMERGE(c, cmdParser[1:])
 
-		handlers.GET(cmdParser[1:], conn)
+	case "LCS":
+		handlers.LCS(c, cmdParser[1:])
 
 	case "TYPE":
-		key, ok := cmdParser[1].(string)
-		if !ok {
-			fmt.Fprintf(conn, "+none\r\n")
+		if len(cmdParser) != 2 {
+			w.WriteError("ERR wrong number of arguments for 'type' command")
 			break
 		}
+		handlers.TYPE(c, cmdParser[1:])
 
-		val, exists := redisKeyTypeStore[key]
+	case "DEL":
+		handlers.DEL(c, cmdParser[1:])
 
-		if exists {
-			fmt.Fprintf(conn, "+%s\r\n", val)
-		} else {
-			fmt.Fprintf(conn, "+none\r\n")
-		}
+	case "UNLINK":
+		handlers.UNLINK(c, cmdParser[1:])
+
+	case "EXISTS":
+		handlers.EXISTS(c, cmdParser[1:])
+
+	case "TOUCH":
+		handlers.TOUCH(c, cmdParser[1:])
+
+	case "RENAME":
+		handlers.RENAME(c, cmdParser[1:])
+
+	case "RENAMENX":
+		handlers.RENAMENX(c, cmdParser[1:])
+
+	case "COPY":
+		handlers.COPY(c, cmdParser[1:])
+
+	case "SELECT":
+		handlers.SELECT(c, cmdParser[1:])
+
+	case "MOVE":
+		handlers.MOVE(c, cmdParser[1:])
+
+	case "SWAPDB":
+		handlers.SWAPDB(c, cmdParser[1:])
+
+	case "DBSIZE":
+		handlers.DBSIZE(c, cmdParser[1:])
+
+	case "FLUSHDB":
+		handlers.FLUSHDB(c, cmdParser[1:])
+
+	case "FLUSHALL":
+		handlers.FLUSHALL(c, cmdParser[1:])
+
+	case "KEYS":
+		handlers.KEYS(c, cmdParser[1:])
+
+	case "SCAN":
+		handlers.SCAN(c, cmdParser[1:])
+
+	case "EXPIRE":
+		handlers.EXPIRE(c, cmdParser[1:])
+
+	case "PEXPIRE":
+		handlers.PEXPIRE(c, cmdParser[1:])
+
+	case "EXPIREAT":
+		handlers.EXPIREAT(c, cmdParser[1:])
+
+	case "PEXPIREAT":
+		handlers.PEXPIREAT(c, cmdParser[1:])
+
+	case "TTL":
+		handlers.TTL(c, cmdParser[1:])
+
+	case "PTTL":
+		handlers.PTTL(c, cmdParser[1:])
+
+	case "EXPIRETIME":
+		handlers.EXPIRETIME(c, cmdParser[1:])
+
+	case "PEXPIRETIME":
+		handlers.PEXPIRETIME(c, cmdParser[1:])
+
+	case "PERSIST":
+		handlers.PERSIST(c, cmdParser[1:])
 
 	case "LRANGE":
-		res, err := handlers.LRANGE(cmdParser[1:])
-		if err != nil {
-			conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
-		} else {
-			fmt.Fprintf(conn, "*%d\r\n", len(res))
-			for _, v := range res {
-				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
-			}
-		}
+		handlers.LRANGE(c, cmdParser[1:])
 
 	case "LPUSH":
-		redisKeyTypeStore[cmdParser[1].(string)] = "list"
-		length, err := handlers.LPUSH(cmdParser[1:])
-		if err != nil {
-			conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
-		} else {
-			fmt.Fprintf(conn, ":%d\r\n", length)
-		}
+		handlers.LPUSH(c, cmdParser[1:])
 
 	case "LLEN":
-		length := handlers.LLEN(cmdParser[1:])
-
-		fmt.Fprintf(conn, ":%d\r\n", length)
+		handlers.LLEN(c, cmdParser[1:])
 
 	case "LPOP":
+		handlers.LPOP(c, cmdParser[1:])
 
-		res, ok := handlers.LPOP(cmdParser[1:])
+	case "RPOP":
+		handlers.RPOP(c, cmdParser[1:])
 
-		if len(res) == 1 {
-			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(res[0]), res[0])
-		} else if ok {
-			fmt.Fprintf(conn, "*%d\r\n", len(res))
-			for _, v := range res {
-				fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(v), v)
-			}
-		} else {
-			fmt.Fprintf(conn, "$-1\r\n")
-		}
+	case "LINDEX":
+		handlers.LINDEX(c, cmdParser[1:])
+
+	case "LSET":
+		handlers.LSET(c, cmdParser[1:])
+
+	case "LINSERT":
+		handlers.LINSERT(c, cmdParser[1:])
+
+	case "LREM":
+		handlers.LREM(c, cmdParser[1:])
+
+	case "LTRIM":
+		handlers.LTRIM(c, cmdParser[1:])
+
+	case "LPOS":
+		handlers.LPOS(c, cmdParser[1:])
+
+	case "LPUSHX":
+		handlers.LPUSHX(c, cmdParser[1:])
+
+	case "RPUSHX":
+		handlers.RPUSHX(c, cmdParser[1:])
 
 	case "RPUSH":
-		redisKeyTypeStore[cmdParser[1].(string)] = "list"
-		length, err := handlers.RPUSH(cmdParser[1:])
-		if err != nil {
-			conn.Write([]byte("-ERR " + err.Error() + "\r\n"))
-		} else {
-			fmt.Fprintf(conn, ":%d\r\n", length)
-		}
+		handlers.RPUSH(c, cmdParser[1:])
 
 	case "BLPOP":
-		key := fmt.Sprintf("%s", cmdParser[1])
-		val, ok := handlers.BLPOP(cmdParser[1:])
+		handlers.BLPOP(c, cmdParser[1:])
 
-		if ok {
-			fmt.Fprintf(conn, "*2\r\n")
-			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(key), key)
-			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(val), val)
-		} else {
-			fmt.Fprintf(conn, "*-1\r\n")
-		}
+	case "BRPOP":
+		handlers.BRPOP(c, cmdParser[1:])
 
-	case "XADD":
-		key := fmt.Sprintf("%s", cmdParser[1])
-		redisKeyTypeStore[key] = "stream"
+	case "LMOVE":
+		handlers.LMOVE(c, cmdParser[1:])
 
-		id, err := handlers.XADD(cmdParser[1:])
-		if err != nil {
-			fmt.Fprintf(conn, "-%s\r\n", err.Error())
-		} else {
-			// send RESP bulk string with the ID
-			fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(id), id)
+	case "RPOPLPUSH":
+		handlers.RPOPLPUSH(c, cmdParser[1:])
+
+	case "BLMOVE":
+		handlers.BLMOVE(c, cmdParser[1:])
+
+	case "BRPOPLPUSH":
+		handlers.BRPOPLPUSH(c, cmdParser[1:])
+
+	case "LMPOP":
+		handlers.LMPOP(c, cmdParser[1:])
+
+	case "BLMPOP":
+		handlers.BLMPOP(c, cmdParser[1:])
+
+	case "HSET":
+		handlers.HSET(c, cmdParser[1:])
+
+	case "HSETNX":
+		handlers.HSETNX(c, cmdParser[1:])
+
+	case "HGET":
+		handlers.HGET(c, cmdParser[1:])
+
+	case "HMGET":
+		handlers.HMGET(c, cmdParser[1:])
+
+	case "HDEL":
+		handlers.HDEL(c, cmdParser[1:])
+
+	case "HLEN":
+		handlers.HLEN(c, cmdParser[1:])
+
+	case "HSTRLEN":
+		handlers.HSTRLEN(c, cmdParser[1:])
+
+	case "HEXISTS":
+		handlers.HEXISTS(c, cmdParser[1:])
+
+	case "HGETALL":
+		handlers.HGETALL(c, cmdParser[1:])
+
+	case "HKEYS":
+		handlers.HKEYS(c, cmdParser[1:])
+
+	case "HVALS":
+		handlers.HVALS(c, cmdParser[1:])
+
+	case "HINCRBY":
+		handlers.HINCRBY(c, cmdParser[1:])
+
+	case "HINCRBYFLOAT":
+		handlers.HINCRBYFLOAT(c, cmdParser[1:])
+
+	case "HRANDFIELD":
+		handlers.HRANDFIELD(c, cmdParser[1:])
+
+	case "HEXPIRE":
+		handlers.HEXPIRE(c, cmdParser[1:])
+
+	case "HPEXPIRE":
+		handlers.HPEXPIRE(c, cmdParser[1:])
+
+	case "HEXPIREAT":
+		handlers.HEXPIREAT(c, cmdParser[1:])
+
+	case "HPEXPIREAT":
+		handlers.HPEXPIREAT(c, cmdParser[1:])
+
+	case "HTTL":
+		handlers.HTTL(c, cmdParser[1:])
+
+	case "HPTTL":
+		handlers.HPTTL(c, cmdParser[1:])
+
+	case "HEXPIRETIME":
+		handlers.HEXPIRETIME(c, cmdParser[1:])
+
+	case "HPEXPIRETIME":
+		handlers.HPEXPIRETIME(c, cmdParser[1:])
+
+	case "HPERSIST":
+		handlers.HPERSIST(c, cmdParser[1:])
+
+	case "HGETDEL":
+		handlers.HGETDEL(c, cmdParser[1:])
+
+	case "HGETEX":
+		handlers.HGETEX(c, cmdParser[1:])
+
+	case "XADD":
+		if len(cmdParser) < 5 || len(cmdParser)%2 == 0 {
+			w.WriteError("ERR wrong number of arguments for 'xadd' command")
+			break
 		}
+		handlers.XADD(c, cmdParser[1:])
 
 	case "XRANGE":
-		handlers.XRANGE(conn, cmdParser[1:])
+		if len(cmdParser) < 4 {
+			w.WriteError("ERR wrong number of arguments for 'xrange' command")
+			break
+		}
+		handlers.XRANGE(c, cmdParser[1:])
 
 	case "XREAD":
-
-		handlers.XREAD(conn, cmdParser[1:])
+		if len(cmdParser) < 4 {
+			w.WriteError("ERR wrong number of arguments for 'xread' command")
+			break
+		}
+		handlers.XREAD(c, cmdParser[1:])
 
 	case "INCR":
-		handlers.INCR(cmdParser[1:], conn)
+		handlers.INCR(c, cmdParser[1:])
+
+	case "DECR":
+		handlers.DECR(c, cmdParser[1:])
+
+	case "INCRBY":
+		handlers.INCRBY(c, cmdParser[1:])
+
+	case "DECRBY":
+		handlers.DECRBY(c, cmdParser[1:])
+
+	case "INCRBYFLOAT":
+		handlers.INCRBYFLOAT(c, cmdParser[1:])
+
+	case "HELLO":
+		handlers.HELLO(c, cmdParser[1:])
 
 	case "INFO":
-		handlers.INFO(conn, cmdParser)
+		handlers.INFO(c, cmdParser)
 
 	case "PSYNC":
-		handlers.PSYNC(conn)
+		handlers.PSYNC(c)
 
 	case "REPLCONF":
-		if len(cmdParser) >= 2 && strings.ToUpper(fmt.Sprintf("%v", cmdParser[1])) == "ACK" {
-			conn.Write([]byte("+OK\r\n"))
-		} else {
-			conn.Write([]byte("+OK\r\n"))
-		}
+		w.WriteSimpleString("OK")
 
 	default:
-		conn.Write([]byte("-ERR unknown command\r\n"))
+		w.WriteError("ERR unknown command '" + string(cmdParser[0]) + "'")
 	}
 }
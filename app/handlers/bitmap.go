@@ -0,0 +1,656 @@
+package handlers
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// Bits are numbered from the most significant bit of the first byte, so
+// bit 0 is 0x80 of byte 0, as in Redis.
+
+var errBitOffset = errors.New("ERR bit offset is not an integer or out of range")
+
+// parseBitOffset parses a bit offset, which must address a bit within a
+// string of at most maxStringLen bytes. With hash set, "#N" is accepted
+// and means N*width, as BITFIELD does.
+func parseBitOffset(b []byte, hash bool, width int64) (int64, error) {
+	mult := int64(1)
+	if hash && len(b) > 0 && b[0] == '#' {
+		b, mult = b[1:], width
+	}
+	n, err := utils.ParseInt(b)
+	if err != nil || n < 0 || n > math.MaxInt64/mult {
+		return 0, errBitOffset
+	}
+	n *= mult
+	if n>>3 >= maxStringLen {
+		return 0, errBitOffset
+	}
+	return n, nil
+}
+
+// growString returns the string at key, created or padded with zero bytes
+// so that it is at least size bytes long.
+func growString(db *store.DB, key string, size int64) ([]byte, error) {
+	v, err := db.String(key)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		v = make([]byte, size)
+		db.Set(key, v)
+		return v, nil
+	}
+	if int64(len(v)) < size {
+		v = append(v, make([]byte, size-int64(len(v)))...)
+		db.Overwrite(key, v)
+	}
+	return v, nil
+}
+
+// SETBIT key offset value
+func SETBIT(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'setbit' command")
+		return
+	}
+	offset, err := parseBitOffset(cmd[1], false, 0)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if len(cmd[2]) != 1 || (cmd[2][0] != '0' && cmd[2][0] != '1') {
+		c.W.WriteError("ERR bit is not an integer or out of range")
+		return
+	}
+
+	v, err := growString(c.DB(), string(cmd[0]), offset>>3+1)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	mask := byte(0x80) >> (offset & 7)
+	old := v[offset>>3]&mask != 0
+	if cmd[2][0] == '1' {
+		v[offset>>3] |= mask
+	} else {
+		v[offset>>3] &^= mask
+	}
+	if old {
+		c.W.WriteInt(1)
+	} else {
+		c.W.WriteInt(0)
+	}
+}
+
+// GETBIT key offset
+func GETBIT(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'getbit' command")
+		return
+	}
+	offset, err := parseBitOffset(cmd[1], false, 0)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	v, err := c.DB().String(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if offset>>3 < int64(len(v)) && v[offset>>3]&(0x80>>(offset&7)) != 0 {
+		c.W.WriteInt(1)
+	} else {
+		c.W.WriteInt(0)
+	}
+}
+
+// parseBitRange parses the start end [BYTE | BIT] arguments of BITCOUNT and
+// BITPOS and returns the range in bits, clamped to a string of n bytes.
+// ok is false when the range is empty.
+func parseBitRange(args [][]byte, n int64) (start, end int64, ok bool, err error) {
+	if start, err = utils.ParseInt(args[0]); err != nil {
+		return
+	}
+	if end, err = utils.ParseInt(args[1]); err != nil {
+		return
+	}
+	isBit := false
+	if len(args) == 3 {
+		switch strings.ToUpper(string(args[2])) {
+		case "BIT":
+			isBit = true
+		case "BYTE":
+		default:
+			err = errors.New("ERR syntax error")
+			return
+		}
+	}
+
+	total := n
+	if isBit {
+		total = n * 8
+	}
+	if start < 0 && end < 0 && start > end {
+		return 0, 0, false, nil
+	}
+	if start < 0 {
+		start = max(total+start, 0)
+	}
+	if end < 0 {
+		end = max(total+end, 0)
+	}
+	end = min(end, total-1)
+	if start > end {
+		return 0, 0, false, nil
+	}
+	if !isBit {
+		start, end = start*8, end*8+7
+	}
+	return start, end, true, nil
+}
+
+// BITCOUNT key [start end [BYTE | BIT]]
+func BITCOUNT(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'bitcount' command")
+		return
+	}
+	if len(cmd) != 1 && len(cmd) != 3 && len(cmd) != 4 {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+	v, err := c.DB().String(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	start, end := int64(0), int64(len(v))*8-1
+	if len(cmd) > 1 {
+		var ok bool
+		start, end, ok, err = parseBitRange(cmd[1:], int64(len(v)))
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		if !ok {
+			c.W.WriteInt(0)
+			return
+		}
+	}
+	if len(v) == 0 {
+		c.W.WriteInt(0)
+		return
+	}
+
+	count := popcount(v[start>>3 : end>>3+1])
+	// Take out the bits of the first and last byte outside the range.
+	count -= bits.OnesCount8(v[start>>3] & ^(0xff >> (start & 7)))
+	count -= bits.OnesCount8(v[end>>3] & (0xff >> (end&7 + 1)))
+	c.W.WriteInt(int64(count))
+}
+
+// popcount counts the set bits of b a word at a time.
+func popcount(b []byte) int {
+	n := 0
+	for len(b) >= 32 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(b)) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(b[8:])) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(b[16:])) +
+			bits.OnesCount64(binary.LittleEndian.Uint64(b[24:]))
+		b = b[32:]
+	}
+	for len(b) >= 8 {
+		n += bits.OnesCount64(binary.LittleEndian.Uint64(b))
+		b = b[8:]
+	}
+	for _, x := range b {
+		n += bits.OnesCount8(x)
+	}
+	return n
+}
+
+// BITPOS key bit [start [end [BYTE | BIT]]]
+func BITPOS(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'bitpos' command")
+		return
+	}
+	if len(cmd[1]) != 1 || (cmd[1][0] != '0' && cmd[1][0] != '1') {
+		c.W.WriteError("ERR The bit argument must be 1 or 0.")
+		return
+	}
+	bit := cmd[1][0] == '1'
+
+	v, err := c.DB().String(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	// A missing key is all clear bits, whatever range is asked for: the
+	// range is not even parsed.
+	if v == nil {
+		if bit {
+			c.W.WriteInt(-1)
+		} else {
+			c.W.WriteInt(0)
+		}
+		return
+	}
+	if len(cmd) > 5 {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+
+	n := int64(len(v))
+	start, end := int64(0), n*8-1
+	endGiven := len(cmd) > 3
+	if len(cmd) > 2 {
+		args := cmd[2:]
+		if !endGiven {
+			args = [][]byte{args[0], []byte("-1")}
+		}
+		var ok bool
+		start, end, ok, err = parseBitRange(args, n)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		if !ok && n > 0 {
+			c.W.WriteInt(-1)
+			return
+		}
+	}
+	if n == 0 {
+		if bit {
+			c.W.WriteInt(-1)
+		} else {
+			c.W.WriteInt(0)
+		}
+		return
+	}
+
+	pos := bitpos(v, bit, start, end)
+	// Looking for a clear bit without an explicit end treats the string
+	// as padded with zeros, so the first clear bit is right after it.
+	if pos < 0 && !bit && !endGiven {
+		pos = end + 1
+	}
+	c.W.WriteInt(pos)
+}
+
+// bitpos returns the position of the first bit set to bit between the bit
+// offsets start and end inclusive, or -1.
+func bitpos(v []byte, bit bool, start, end int64) int64 {
+	// skip is the value of a byte with no bit we are looking for.
+	skip := byte(0)
+	if !bit {
+		skip = 0xff
+	}
+	first, last := start>>3, end>>3
+	for i := first; i <= last; i++ {
+		// Skip whole words in the middle of the range.
+		if i > first {
+			for i+8 <= last && binary.LittleEndian.Uint64(v[i:]) == uint64(skip)*0x0101010101010101 {
+				i += 8
+			}
+		}
+		b := v[i] ^ skip
+		if i == first {
+			b &= 0xff >> (start & 7)
+		}
+		if i == last {
+			b &= ^(0xff >> (end&7 + 1))
+		}
+		if b != 0 {
+			return i*8 + int64(bits.LeadingZeros8(b))
+		}
+	}
+	return -1
+}
+
+// BITOP AND | OR | XOR | NOT destkey key [key ...]
+func BITOP(c *Client, cmd [][]byte) {
+	if len(cmd) < 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'bitop' command")
+		return
+	}
+	op := strings.ToUpper(string(cmd[0]))
+	switch op {
+	case "AND", "OR", "XOR":
+	case "NOT":
+		if len(cmd) != 3 {
+			c.W.WriteError("ERR BITOP NOT must be called with a single source key.")
+			return
+		}
+	default:
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+
+	db := c.DB()
+	srcs := make([][]byte, 0, len(cmd)-2)
+	maxLen := 0
+	for _, k := range cmd[2:] {
+		v, err := db.String(string(k))
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		srcs = append(srcs, v)
+		maxLen = max(maxLen, len(v))
+	}
+
+	dest := string(cmd[1])
+	if maxLen == 0 {
+		db.Delete(dest)
+		c.W.WriteInt(0)
+		return
+	}
+
+	// Missing keys and the tails of shorter strings count as zero bytes.
+	res := make([]byte, maxLen)
+	copy(res, srcs[0])
+	switch op {
+	case "NOT":
+		for i := range res {
+			res[i] = ^res[i]
+		}
+	case "AND":
+		for _, s := range srcs[1:] {
+			for i := range res {
+				if i < len(s) {
+					res[i] &= s[i]
+				} else {
+					res[i] = 0
+				}
+			}
+		}
+	case "OR":
+		for _, s := range srcs[1:] {
+			for i := range s {
+				res[i] |= s[i]
+			}
+		}
+	case "XOR":
+		for _, s := range srcs[1:] {
+			for i := range s {
+				res[i] ^= s[i]
+			}
+		}
+	}
+	db.Set(dest, res)
+	c.W.WriteInt(int64(maxLen))
+}
+
+type bitfieldOverflow int
+
+const (
+	overflowWrap bitfieldOverflow = iota
+	overflowSat
+	overflowFail
+)
+
+type bitfieldOp struct {
+	kind     string // GET, SET or INCRBY
+	signed   bool
+	width    int64
+	offset   int64
+	value    int64
+	overflow bitfieldOverflow
+}
+
+// BITFIELD key [GET encoding offset | [OVERFLOW WRAP | SAT | FAIL]
+// SET encoding offset value | INCRBY encoding offset increment ...]
+func BITFIELD(c *Client, cmd [][]byte) {
+	bitfieldGeneric(c, cmd, "bitfield", false)
+}
+
+// BITFIELD_RO key [GET encoding offset ...]
+func BITFIELD_RO(c *Client, cmd [][]byte) {
+	bitfieldGeneric(c, cmd, "bitfield_ro", true)
+}
+
+func bitfieldGeneric(c *Client, cmd [][]byte, name string, readOnly bool) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	var ops []bitfieldOp
+	overflow := overflowWrap
+	write := false
+	for i := 1; i < len(cmd); i++ {
+		kind := strings.ToUpper(string(cmd[i]))
+		if kind == "OVERFLOW" && i+1 < len(cmd) {
+			switch strings.ToUpper(string(cmd[i+1])) {
+			case "WRAP":
+				overflow = overflowWrap
+			case "SAT":
+				overflow = overflowSat
+			case "FAIL":
+				overflow = overflowFail
+			default:
+				c.W.WriteError("ERR Invalid OVERFLOW type specified")
+				return
+			}
+			i++
+			continue
+		}
+
+		nargs := 2
+		if kind == "SET" || kind == "INCRBY" {
+			nargs = 3
+		} else if kind != "GET" {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		if i+nargs >= len(cmd) {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		if readOnly && kind != "GET" {
+			c.W.WriteError("ERR BITFIELD_RO only supports the GET subcommand")
+			return
+		}
+
+		op := bitfieldOp{kind: kind, overflow: overflow}
+		var ok bool
+		op.signed, op.width, ok = parseBitfieldType(cmd[i+1])
+		if !ok {
+			c.W.WriteError("ERR Invalid bitfield type. Use something like i16 u8. Note that u64 is not supported but i64 is.")
+			return
+		}
+		var err error
+		op.offset, err = parseBitOffset(cmd[i+2], true, op.width)
+		if err == nil && (op.offset+op.width-1)>>3 >= maxStringLen {
+			err = errBitOffset
+		}
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		if nargs == 3 {
+			op.value, err = utils.ParseInt(cmd[i+3])
+			if err != nil {
+				c.W.WriteError(err.Error())
+				return
+			}
+			write = true
+		}
+		ops = append(ops, op)
+		i += nargs
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	var v []byte
+	var err error
+	if write {
+		// Grow the string once to cover every write.
+		var size int64
+		for _, op := range ops {
+			if op.kind != "GET" {
+				size = max(size, (op.offset+op.width+7)>>3)
+			}
+		}
+		v, err = growString(db, key, size)
+	} else {
+		v, err = db.String(key)
+	}
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	c.W.WriteArrayLen(len(ops))
+	for _, op := range ops {
+		old := getBitfield(v, op.offset, op.width)
+		if op.kind == "GET" {
+			c.W.WriteInt(bitfieldValue(old, op))
+			continue
+		}
+
+		var n int64
+		var fail bool
+		if op.kind == "SET" {
+			n, fail = bitfieldOverflowed(op, op.value, 0)
+		} else {
+			n, fail = bitfieldOverflowed(op, bitfieldValue(old, op), op.value)
+		}
+		if fail {
+			c.W.WriteNull()
+			continue
+		}
+		setBitfield(v, op.offset, op.width, uint64(n))
+		if op.kind == "SET" {
+			c.W.WriteInt(bitfieldValue(old, op))
+		} else {
+			c.W.WriteInt(n)
+		}
+	}
+}
+
+// parseBitfieldType parses i1 to i64 and u1 to u63.
+func parseBitfieldType(b []byte) (signed bool, width int64, ok bool) {
+	if len(b) < 2 || (b[0] != 'i' && b[0] != 'I' && b[0] != 'u' && b[0] != 'U') {
+		return false, 0, false
+	}
+	signed = b[0] == 'i' || b[0] == 'I'
+	n, err := strconv.ParseInt(string(b[1:]), 10, 64)
+	if err != nil || n < 1 || (signed && n > 64) || (!signed && n > 63) {
+		return false, 0, false
+	}
+	return signed, n, true
+}
+
+// getBitfield reads width bits at offset as an unsigned integer. Bits past
+// the end of v read as zero.
+func getBitfield(v []byte, offset, width int64) uint64 {
+	var n uint64
+	for i := offset; i < offset+width; i++ {
+		n <<= 1
+		if i>>3 < int64(len(v)) && v[i>>3]&(0x80>>(i&7)) != 0 {
+			n |= 1
+		}
+	}
+	return n
+}
+
+// setBitfield writes the low width bits of n at offset. v must be long
+// enough.
+func setBitfield(v []byte, offset, width int64, n uint64) {
+	for i := offset + width - 1; i >= offset; i-- {
+		mask := byte(0x80) >> (i & 7)
+		if n&1 != 0 {
+			v[i>>3] |= mask
+		} else {
+			v[i>>3] &^= mask
+		}
+		n >>= 1
+	}
+}
+
+// bitfieldValue interprets the raw bits of a field, sign extending signed
+// fields.
+func bitfieldValue(raw uint64, op bitfieldOp) int64 {
+	if op.signed && op.width < 64 && raw&(1<<(op.width-1)) != 0 {
+		raw |= ^uint64(0) << op.width
+	}
+	return int64(raw)
+}
+
+// bitfieldOverflowed computes value+incr for the field, applying its
+// overflow policy. fail is set when the policy is FAIL and the result
+// doesn't fit.
+func bitfieldOverflowed(op bitfieldOp, value, incr int64) (n int64, fail bool) {
+	if op.signed {
+		maxv := int64(math.MaxInt64)
+		if op.width < 64 {
+			maxv = 1<<(op.width-1) - 1
+		}
+		minv := -maxv - 1
+		maxIncr, minIncr := maxv-value, minv-value
+
+		var over, under bool
+		if value > maxv || (op.width != 64 && incr > maxIncr) || (value >= 0 && incr > 0 && incr > maxIncr) {
+			over = true
+		} else if value < minv || (op.width != 64 && incr < minIncr) || (value < 0 && incr < 0 && incr < minIncr) {
+			under = true
+		}
+		if !over && !under {
+			return value + incr, false
+		}
+		switch op.overflow {
+		case overflowSat:
+			if over {
+				return maxv, false
+			}
+			return minv, false
+		case overflowFail:
+			return 0, true
+		}
+		// Wrap around by truncating to width bits and sign extending.
+		res := uint64(value) + uint64(incr)
+		if op.width < 64 {
+			if res&(1<<(op.width-1)) != 0 {
+				res |= ^uint64(0) << op.width
+			} else {
+				res &^= ^uint64(0) << op.width
+			}
+		}
+		return int64(res), false
+	}
+
+	maxv := uint64(1)<<op.width - 1
+	uv := uint64(value)
+	var over, under bool
+	if incr >= 0 {
+		over = uv > maxv || uint64(incr) > maxv-uv
+	} else {
+		// -incr as unsigned works for MinInt64 too.
+		under = uint64(-incr) > uv
+	}
+	if !over && !under {
+		return int64(uv + uint64(incr)), false
+	}
+	switch op.overflow {
+	case overflowSat:
+		if over {
+			return int64(maxv), false
+		}
+		return 0, false
+	case overflowFail:
+		return 0, true
+	}
+	return int64((uv + uint64(incr)) & maxv), false
+}
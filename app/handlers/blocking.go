@@ -0,0 +1,601 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// Blocking list commands park a listWaiter on every key they wait for.
+// Commands that add elements to a list signal the key as ready, and the
+// client that pushed serves the waiters in the order they blocked, running
+// the pop for them while it still holds the keyspace lock. This keeps
+// LMOVE atomic and lets the pops be replicated right after the push that
+// caused them.
+
+// waitKey identifies a key a client blocks on. Keys with the same name in
+// different databases are unrelated.
+type waitKey struct {
+	db  int
+	key string
+}
+
+// listPopFunc pops from the list at key on behalf of a blocked client,
+// queueing what it did for replication on p, the client being served by.
+// It returns the reply for the blocked client, or false when the waiter
+// can't be served from key yet.
+type listPopFunc func(p *Client, db *store.DB, key string) (any, bool)
+
+// listUnpopFunc undoes a listPopFunc given the reply it returned, queueing
+// what it did for replication on p.
+type listUnpopFunc func(p *Client, db *store.DB, reply any)
+
+type listWaiter struct {
+	db     int
+	keys   []string
+	pop    listPopFunc
+	result chan any
+}
+
+type ListWaiters struct {
+	waiters map[waitKey][]*listWaiter
+	// ready are keys that got elements while clients wait on them, in the
+	// order they got them.
+	ready []waitKey
+}
+
+var listWaiters = ListWaiters{
+	waiters: make(map[waitKey][]*listWaiter),
+}
+
+// serveListWaiters serves the clients blocked on the list at key, which
+// has just been added to.
+func serveListWaiters(c *Client, db *store.DB, key string) {
+	signalListReady(db, key)
+	handleReadyLists(c)
+}
+
+// serveAllListWaiters serves the waiters of every database, after keys
+// appeared without a push, as SWAPDB does.
+func serveAllListWaiters(c *Client) {
+	keys := make([]waitKey, 0, len(listWaiters.waiters))
+	for wk := range listWaiters.waiters {
+		keys = append(keys, wk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].db != keys[j].db {
+			return keys[i].db < keys[j].db
+		}
+		return keys[i].key < keys[j].key
+	})
+	for _, wk := range keys {
+		signalListReady(store.Select(wk.db), wk.key)
+	}
+	handleReadyLists(c)
+}
+
+func signalListReady(db *store.DB, key string) {
+	wk := waitKey{db.ID, key}
+	if len(listWaiters.waiters[wk]) > 0 {
+		listWaiters.ready = append(listWaiters.ready, wk)
+	}
+}
+
+// handleReadyLists serves waiters on the ready keys until none is left.
+// Serving an LMOVE may make another key ready, which is handled in turn.
+func handleReadyLists(c *Client) {
+	for len(listWaiters.ready) > 0 {
+		wk := listWaiters.ready[0]
+		listWaiters.ready = listWaiters.ready[1:]
+
+		db := store.Select(wk.db)
+		queue := listWaiters.waiters[wk]
+		for i := 0; i < len(queue); {
+			if list, _ := db.List(wk.key); list == nil {
+				break
+			}
+			w := queue[i]
+			reply, ok := w.pop(c, db, wk.key)
+			if !ok {
+				i++
+				continue
+			}
+			removeListWaiter(w)
+			queue = listWaiters.waiters[wk]
+			w.result <- reply
+		}
+	}
+}
+
+func removeListWaiter(w *listWaiter) {
+	for _, k := range w.keys {
+		wk := waitKey{w.db, k}
+		queue := listWaiters.waiters[wk][:0]
+		for _, other := range listWaiters.waiters[wk] {
+			if other != w {
+				queue = append(queue, other)
+			}
+		}
+		if len(queue) == 0 {
+			delete(listWaiters.waiters, wk)
+		} else {
+			listWaiters.waiters[wk] = queue
+		}
+	}
+}
+
+// blockOnLists blocks c until a push to one of keys serves it with pop,
+// until timeout has passed, 0 waiting forever, or until the client
+// disconnects. Inside a transaction it times out straight away: EXEC holds
+// the keyspace lock until the last queued command is done, so no push
+// could ever come. It must be called with the keyspace locked, and returns
+// with it locked again.
+//
+// A client that disconnects after it was served has nobody to hand its
+// reply to, so unpop, when not nil, puts back what pop took.
+func blockOnLists(c *Client, keys []string, timeout time.Duration, pop listPopFunc, unpop listUnpopFunc) (any, bool) {
+	if c.inExec {
+		return nil, false
+	}
+	w := &listWaiter{
+		db:     c.DBIndex(),
+		pop:    pop,
+		result: make(chan any, 1),
+	}
+	// A client takes a single place in the queue of a key however many
+	// times it names it.
+	seen := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if seen[k] {
+			continue
+		}
+		seen[k] = true
+		w.keys = append(w.keys, k)
+		wk := waitKey{w.db, k}
+		listWaiters.waiters[wk] = append(listWaiters.waiters[wk], w)
+	}
+
+	// Let other clients run while we wait for a push.
+	store.Unlock()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
+	gone, stopWatching := c.watchDisconnect()
+	defer stopWatching()
+	var reply any
+	served := false
+	select {
+	case reply = <-w.result:
+		served = true
+	case <-expired:
+	case <-gone:
+	}
+
+	store.Lock()
+	if !served {
+		// A push may have served us while we were taking the lock.
+		select {
+		case reply = <-w.result:
+		default:
+			// Give the place up so pushes go to the next client in line.
+			removeListWaiter(w)
+			return nil, false
+		}
+	}
+	select {
+	case <-gone:
+		// Nobody is left to reply to. Putting the elements back serves
+		// the next client in line, if any.
+		if unpop != nil {
+			unpop(c, store.Select(w.db), reply)
+			handleReadyLists(c)
+		}
+		return nil, false
+	default:
+	}
+	return reply, true
+}
+
+// parseTimeout parses the timeout of a blocking command, in seconds with
+// millisecond precision.
+func parseTimeout(b []byte) (time.Duration, error) {
+	f, err := utils.ParseFloat(b)
+	if err != nil || math.IsInf(f, 0) {
+		return 0, errors.New("ERR timeout is not a float or out of range")
+	}
+	if f < 0 {
+		return 0, errors.New("ERR timeout is negative")
+	}
+	ms := f * 1000
+	if ms >= float64(math.MaxInt64/int64(time.Millisecond)) {
+		return 0, errors.New("ERR timeout is out of range")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
+// parseListEnd parses LEFT or RIGHT, returning true for RIGHT.
+func parseListEnd(b []byte) (back, ok bool) {
+	switch strings.ToUpper(string(b)) {
+	case "LEFT":
+		return false, true
+	case "RIGHT":
+		return true, true
+	}
+	return false, false
+}
+
+func listEndName(back bool) []byte {
+	if back {
+		return []byte("RIGHT")
+	}
+	return []byte("LEFT")
+}
+
+// listPop pops one element from an end of the list at key, deleting the
+// key once it is empty.
+func listPop(db *store.DB, key string, back bool) (string, bool) {
+	list, _ := db.List(key)
+	if list == nil || list.Len() == 0 {
+		return "", false
+	}
+	var v string
+	if back {
+		v, _ = list.PopBack()
+	} else {
+		v, _ = list.PopFront()
+	}
+	if list.Len() == 0 {
+		db.Delete(key)
+	}
+	return v, true
+}
+
+// listPopOne pops a single element for BLPOP and BRPOP, replicated as LPOP
+// or RPOP.
+func listPopOne(back bool) listPopFunc {
+	return func(p *Client, db *store.DB, key string) (any, bool) {
+		v, ok := listPop(db, key, back)
+		if !ok {
+			return nil, false
+		}
+		name := "LPOP"
+		if back {
+			name = "RPOP"
+		}
+		p.AlsoPropagate(db.ID, [][]byte{[]byte(name), []byte(key)})
+		return []string{key, v}, true
+	}
+}
+
+// listUnpop puts the elements popped by listPopOne or listPopMany back at
+// the end they came from, replicated as LPUSH or RPUSH.
+func listUnpop(back bool) listUnpopFunc {
+	return func(p *Client, db *store.DB, reply any) {
+		var key string
+		var vals []string
+		switch r := reply.(type) {
+		case []string:
+			key, vals = r[0], r[1:]
+		case []any:
+			key, vals = r[0].(string), r[1].([]string)
+		}
+		list, err := db.List(key)
+		if err != nil {
+			// The key was overwritten in the meantime; there is
+			// nowhere to put the elements back.
+			return
+		}
+		if list == nil {
+			list = store.NewList()
+			db.Set(key, list)
+		}
+		// Push the last popped first, so the order is as before.
+		name := "LPUSH"
+		if back {
+			name = "RPUSH"
+		}
+		argv := [][]byte{[]byte(name), []byte(key)}
+		for i := len(vals) - 1; i >= 0; i-- {
+			if back {
+				list.PushBack(vals[i])
+			} else {
+				list.PushFront(vals[i])
+			}
+			argv = append(argv, []byte(vals[i]))
+		}
+		p.AlsoPropagate(db.ID, argv)
+		signalListReady(db, key)
+	}
+}
+
+// listPopMany pops up to count elements for LMPOP, replicated as LPOP or
+// RPOP with a count.
+func listPopMany(back bool, count int64) listPopFunc {
+	return func(p *Client, db *store.DB, key string) (any, bool) {
+		list, _ := db.List(key)
+		if list == nil {
+			return nil, false
+		}
+		n := int(min(count, int64(list.Len())))
+		vals := make([]string, 0, n)
+		for i := 0; i < n; i++ {
+			v, _ := listPop(db, key, back)
+			vals = append(vals, v)
+		}
+		name := "LPOP"
+		if back {
+			name = "RPOP"
+		}
+		p.AlsoPropagate(db.ID, [][]byte{[]byte(name), []byte(key), []byte(strconv.Itoa(n))})
+		return []any{key, vals}, true
+	}
+}
+
+// listMove moves an element from the list it is called on to dst, for
+// LMOVE and its variants, all replicated as LMOVE.
+func listMove(dst string, fromBack, toBack bool) listPopFunc {
+	return func(p *Client, db *store.DB, src string) (any, bool) {
+		// Wait until dst is a list again rather than lose the element.
+		if _, err := db.List(dst); err != nil {
+			return nil, false
+		}
+		v, ok := listPop(db, src, fromBack)
+		if !ok {
+			return nil, false
+		}
+		// Look dst up after the pop, which may have deleted it when it is
+		// also the source.
+		list, _ := db.List(dst)
+		if list == nil {
+			list = store.NewList()
+			db.Set(dst, list)
+		}
+		if toBack {
+			list.PushBack(v)
+		} else {
+			list.PushFront(v)
+		}
+		signalListReady(db, dst)
+		p.AlsoPropagate(db.ID, [][]byte{[]byte("LMOVE"), []byte(src), []byte(dst),
+			listEndName(fromBack), listEndName(toBack)})
+		return v, true
+	}
+}
+
+// BLPOP key [key ...] timeout
+func BLPOP(c *Client, cmd [][]byte) {
+	blockingPopGeneric(c, cmd, "blpop", false)
+}
+
+// BRPOP key [key ...] timeout
+func BRPOP(c *Client, cmd [][]byte) {
+	blockingPopGeneric(c, cmd, "brpop", true)
+}
+
+// blockingPopGeneric pops from the first non-empty list among the keys,
+// blocking until one is pushed to if they are all empty.
+func blockingPopGeneric(c *Client, cmd [][]byte, name string, back bool) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	timeout, err := parseTimeout(cmd[len(cmd)-1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	keys := make([]string, len(cmd)-1)
+	for i, k := range cmd[:len(cmd)-1] {
+		keys[i] = string(k)
+	}
+
+	pop := listPopOne(back)
+	if reply, ok, err := popFirstNonEmpty(c, keys, pop); err != nil {
+		c.W.WriteError(err.Error())
+		return
+	} else if ok {
+		c.W.WriteValue(reply)
+		return
+	}
+
+	reply, ok := blockOnLists(c, keys, timeout, pop, listUnpop(back))
+	if !ok {
+		c.W.WriteNullArray()
+		return
+	}
+	c.W.WriteValue(reply)
+}
+
+// popFirstNonEmpty runs pop on the first of keys holding a list. It fails
+// on the first key holding something else.
+func popFirstNonEmpty(c *Client, keys []string, pop listPopFunc) (any, bool, error) {
+	db := c.DB()
+	for _, k := range keys {
+		list, err := db.List(k)
+		if err != nil {
+			return nil, false, err
+		}
+		if list != nil {
+			reply, ok := pop(c, db, k)
+			return reply, ok, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// LMOVE source destination LEFT | RIGHT LEFT | RIGHT
+func LMOVE(c *Client, cmd [][]byte) {
+	if len(cmd) != 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'lmove' command")
+		return
+	}
+	fromBack, ok1 := parseListEnd(cmd[2])
+	toBack, ok2 := parseListEnd(cmd[3])
+	if !ok1 || !ok2 {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+	lmoveGeneric(c, cmd[0], cmd[1], fromBack, toBack, false, 0)
+}
+
+// RPOPLPUSH source destination
+func RPOPLPUSH(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'rpoplpush' command")
+		return
+	}
+	lmoveGeneric(c, cmd[0], cmd[1], true, false, false, 0)
+}
+
+// BLMOVE source destination LEFT | RIGHT LEFT | RIGHT timeout
+func BLMOVE(c *Client, cmd [][]byte) {
+	if len(cmd) != 5 {
+		c.W.WriteError("ERR wrong number of arguments for 'blmove' command")
+		return
+	}
+	fromBack, ok1 := parseListEnd(cmd[2])
+	toBack, ok2 := parseListEnd(cmd[3])
+	if !ok1 || !ok2 {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+	timeout, err := parseTimeout(cmd[4])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	lmoveGeneric(c, cmd[0], cmd[1], fromBack, toBack, true, timeout)
+}
+
+// BRPOPLPUSH source destination timeout
+func BRPOPLPUSH(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'brpoplpush' command")
+		return
+	}
+	timeout, err := parseTimeout(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	lmoveGeneric(c, cmd[0], cmd[1], true, false, true, timeout)
+}
+
+func lmoveGeneric(c *Client, src, dst []byte, fromBack, toBack, block bool, timeout time.Duration) {
+	db := c.DB()
+	if _, err := db.List(string(src)); err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if _, err := db.List(string(dst)); err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	move := listMove(string(dst), fromBack, toBack)
+	if reply, ok := move(c, db, string(src)); ok {
+		handleReadyLists(c)
+		c.W.WriteValue(reply)
+		return
+	}
+	if !block {
+		c.W.WriteNull()
+		return
+	}
+
+	// A moved element is in dst already, so nothing is lost if the client
+	// is gone by the time it is served.
+	reply, ok := blockOnLists(c, []string{string(src)}, timeout, move, nil)
+	if !ok {
+		c.W.WriteNullArray()
+		return
+	}
+	c.W.WriteValue(reply)
+}
+
+// LMPOP numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func LMPOP(c *Client, cmd [][]byte) {
+	if len(cmd) < 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'lmpop' command")
+		return
+	}
+	mpopGeneric(c, cmd, false, 0)
+}
+
+// BLMPOP timeout numkeys key [key ...] LEFT | RIGHT [COUNT count]
+func BLMPOP(c *Client, cmd [][]byte) {
+	if len(cmd) < 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'blmpop' command")
+		return
+	}
+	timeout, err := parseTimeout(cmd[0])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	mpopGeneric(c, cmd[1:], true, timeout)
+}
+
+func mpopGeneric(c *Client, cmd [][]byte, block bool, timeout time.Duration) {
+	numKeys, err := utils.ParseInt(cmd[0])
+	if err != nil || numKeys <= 0 {
+		c.W.WriteError("ERR numkeys should be greater than 0")
+		return
+	}
+	// Compare before any arithmetic so a huge numkeys cannot overflow.
+	if numKeys > int64(len(cmd)-2) {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+	keys := make([]string, numKeys)
+	for i := range keys {
+		keys[i] = string(cmd[1+i])
+	}
+	back, ok := parseListEnd(cmd[1+numKeys])
+	if !ok {
+		c.W.WriteError("ERR syntax error")
+		return
+	}
+	count := int64(1)
+	opts := cmd[2+numKeys:]
+	if len(opts) != 0 {
+		if len(opts) != 2 || !strings.EqualFold(string(opts[0]), "COUNT") {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		count, err = utils.ParseInt(opts[1])
+		if err != nil || count <= 0 {
+			c.W.WriteError("ERR count should be greater than 0")
+			return
+		}
+	}
+
+	pop := listPopMany(back, count)
+	if reply, ok, err := popFirstNonEmpty(c, keys, pop); err != nil {
+		c.W.WriteError(err.Error())
+		return
+	} else if ok {
+		c.W.WriteValue(reply)
+		return
+	}
+	if !block {
+		c.W.WriteNullArray()
+		return
+	}
+
+	reply, ok := blockOnLists(c, keys, timeout, pop, listUnpop(back))
+	if !ok {
+		c.W.WriteNullArray()
+		return
+	}
+	c.W.WriteValue(reply)
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+var nextClientID atomic.Int64
+
+// Client is the state attached to a single connection. The link to our
+// master has a Client too, writing its replies to io.Discard.
+type Client struct {
+	ID   int64
+	Name string
+	W    *utils.Writer
+
+	// conn and rd are set for network clients, so blocking commands can
+	// tell when the client goes away.
+	conn net.Conn
+	rd   *utils.Reader
+
+	db int
+	// rewritten replaces the current command in the replication stream
+	// when executing it again would not give the same result.
+	rewritten [][]byte
+	// alsoPropagate are extra writes the command caused, such as pops made
+	// for blocked clients, sent to replicas after the command itself.
+	alsoPropagate []Propagation
+	// inExec is set while EXEC runs the queued commands of a transaction,
+	// which must not block.
+	inExec bool
+}
+
+// SetInExec marks whether the client is running the commands of a
+// transaction.
+func (c *Client) SetInExec(inExec bool) {
+	c.inExec = inExec
+}
+
+// Propagation is a command for the replication stream and the database it
+// applies to.
+type Propagation struct {
+	DB   int
+	Argv [][]byte
+}
+
+func NewClient(out io.Writer) *Client {
+	return &Client{
+		ID: nextClientID.Add(1),
+		W:  utils.NewWriter(out),
+	}
+}
+
+// NewConnClient returns the Client of a network connection whose commands
+// are read with rd.
+func NewConnClient(conn net.Conn, rd *utils.Reader) *Client {
+	c := NewClient(conn)
+	c.conn = conn
+	c.rd = rd
+	return c
+}
+
+// watchDisconnect returns a channel that is closed if the client
+// disconnects, and a function to stop watching, which must be called before
+// the client's connection is read again.
+func (c *Client) watchDisconnect() (<-chan struct{}, func()) {
+	gone := make(chan struct{})
+	if c.conn == nil {
+		return gone, func() {}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		err := c.rd.ReadAhead()
+		var nerr net.Error
+		if errors.Is(err, bufio.ErrBufferFull) || (errors.As(err, &nerr) && nerr.Timeout()) {
+			return
+		}
+		close(gone)
+	}()
+	stop := func() {
+		// Interrupt the read ahead, then let reads block again.
+		c.conn.SetReadDeadline(time.Now())
+		<-done
+		c.conn.SetReadDeadline(time.Time{})
+	}
+	return gone, stop
+}
+
+// DB returns the database the client's commands operate on.
+func (c *Client) DB() *store.DB {
+	return store.Select(c.db)
+}
+
+// RewriteCommand makes argv, rather than the command being executed, the one
+// propagated to replicas.
+func (c *Client) RewriteCommand(argv [][]byte) {
+	c.rewritten = argv
+}
+
+// TakeRewrite returns the command set by RewriteCommand, if any, and clears
+// it.
+func (c *Client) TakeRewrite() [][]byte {
+	argv := c.rewritten
+	c.rewritten = nil
+	return argv
+}
+
+// AlsoPropagate queues argv to be sent to replicas, in database db, after
+// the command being executed.
+func (c *Client) AlsoPropagate(db int, argv [][]byte) {
+	c.alsoPropagate = append(c.alsoPropagate, Propagation{db, argv})
+}
+
+// TakeAlsoPropagate returns the commands queued by AlsoPropagate and clears
+// them.
+func (c *Client) TakeAlsoPropagate() []Propagation {
+	p := c.alsoPropagate
+	c.alsoPropagate = nil
+	return p
+}
+
+// DBIndex returns the number of the database picked with SELECT.
+func (c *Client) DBIndex() int {
+	return c.db
+}
+
+// HELLO [protover [AUTH username password] [SETNAME clientname]]
+func HELLO(c *Client, cmd [][]byte) {
+	proto := c.W.Proto()
+	if len(cmd) > 0 {
+		v, err := utils.ParseInt(cmd[0])
+		if err != nil {
+			c.W.WriteError("ERR Protocol version is not an integer or out of range")
+			return
+		}
+		if v != 2 && v != 3 {
+			c.W.WriteError("NOPROTO unsupported protocol version")
+			return
+		}
+		proto = int(v)
+	}
+
+	name := c.Name
+	for i := 1; i < len(cmd); i++ {
+		opt := strings.ToUpper(string(cmd[i]))
+		switch {
+		case opt == "AUTH" && i+2 < len(cmd):
+			// Only the default user exists and it has no password.
+			if string(cmd[i+1]) != "default" {
+				c.W.WriteError("WRONGPASS invalid username-password pair or user is disabled.")
+				return
+			}
+			i += 2
+		case opt == "SETNAME" && i+1 < len(cmd):
+			if !validClientName(cmd[i+1]) {
+				c.W.WriteError("ERR Client names cannot contain spaces, newlines or special characters.")
+				return
+			}
+			name = string(cmd[i+1])
+			i++
+		default:
+			c.W.WriteError("ERR Syntax error in HELLO option '" + string(cmd[i]) + "'")
+			return
+		}
+	}
+
+	c.Name = name
+	c.W.SetProto(proto)
+
+	r := role()
+	if r == "slave" {
+		r = "replica"
+	}
+
+	c.W.WriteMapLen(7)
+	c.W.WriteBulkString("server")
+	c.W.WriteBulkString("redis")
+	c.W.WriteBulkString("version")
+	c.W.WriteBulkString("7.4.0")
+	c.W.WriteBulkString("proto")
+	c.W.WriteInt(int64(proto))
+	c.W.WriteBulkString("id")
+	c.W.WriteInt(c.ID)
+	c.W.WriteBulkString("mode")
+	c.W.WriteBulkString("standalone")
+	c.W.WriteBulkString("role")
+	c.W.WriteBulkString(r)
+	c.W.WriteBulkString("modules")
+	c.W.WriteArrayLen(0)
+}
+
+// validClientName reports whether name is made of printable ASCII other
+// than space, the bytes Redis allows in a client name.
+func validClientName(name []byte) bool {
+	for _, b := range name {
+		if b < '!' || b > '~' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+var errOverflow = errors.New("ERR increment or decrement would overflow")
+
+// INCR key
+func INCR(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'incr' command")
+		return
+	}
+	incrDecr(c, cmd[0], 1)
+}
+
+// DECR key
+func DECR(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'decr' command")
+		return
+	}
+	incrDecr(c, cmd[0], -1)
+}
+
+// INCRBY key increment
+func INCRBY(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'incrby' command")
+		return
+	}
+	n, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	incrDecr(c, cmd[0], n)
+}
+
+// DECRBY key decrement
+func DECRBY(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'decrby' command")
+		return
+	}
+	n, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if n == math.MinInt64 {
+		c.W.WriteError("ERR decrement would overflow")
+		return
+	}
+	incrDecr(c, cmd[0], -n)
+}
+
+// incrDecr adds delta to the integer stored at key, creating it as 0 when
+// missing. The TTL of an existing key is kept.
+func incrDecr(c *Client, k []byte, delta int64) {
+	db := c.DB()
+	key := string(k)
+
+	value, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	var n int64
+	if value != nil {
+		n, err = utils.ParseInt(value)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+	}
+	if (delta < 0 && n < math.MinInt64-delta) || (delta > 0 && n > math.MaxInt64-delta) {
+		c.W.WriteError(errOverflow.Error())
+		return
+	}
+	n += delta
+
+	if value == nil {
+		db.Set(key, strconv.AppendInt(nil, n, 10))
+	} else {
+		db.Overwrite(key, strconv.AppendInt(nil, n, 10))
+	}
+	c.W.WriteInt(n)
+}
+
+// INCRBYFLOAT key increment
+//
+// The result depends on float formatting, so replicas are sent the final
+// value as a SET instead of the increment.
+func INCRBYFLOAT(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'incrbyfloat' command")
+		return
+	}
+	incr, err := utils.ParseFloat(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	value, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	var f float64
+	if value != nil {
+		f, err = utils.ParseFloat(value)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+	}
+	f += incr
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		c.W.WriteError("ERR increment would produce NaN or Infinity")
+		return
+	}
+
+	out := strconv.AppendFloat(nil, f, 'f', -1, 64)
+	if value == nil {
+		db.Set(key, out)
+	} else {
+		db.Overwrite(key, out)
+	}
+	c.RewriteCommand([][]byte{[]byte("SET"), cmd[0], out, []byte("KEEPTTL")})
+	c.W.WriteBulk(out)
+}
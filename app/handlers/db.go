@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// parseDBIndex parses a database number, reporting errors the way SELECT
+// does.
+func parseDBIndex(b []byte) (int, error) {
+	n, err := utils.ParseInt(b)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 || n >= int64(store.NumDatabases()) {
+		return 0, errDBIndexOutOfRange
+	}
+	return int(n), nil
+}
+
+var errDBIndexOutOfRange = errors.New("ERR DB index is out of range")
+
+// SELECT index
+func SELECT(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'select' command")
+		return
+	}
+	i, err := parseDBIndex(cmd[0])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	c.db = i
+	c.W.WriteSimpleString("OK")
+}
+
+// MOVE key db
+func MOVE(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'move' command")
+		return
+	}
+	i, err := parseDBIndex(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if i == c.DBIndex() {
+		c.W.WriteError("ERR source and destination objects are the same")
+		return
+	}
+
+	key := string(cmd[0])
+	src, dst := c.DB(), store.Select(i)
+	v, ok := src.Lookup(key)
+	if !ok {
+		c.W.WriteInt(0)
+		return
+	}
+	if _, exists := dst.Lookup(key); exists {
+		c.W.WriteInt(0)
+		return
+	}
+
+	at, volatile := src.Expire(key)
+	src.Delete(key)
+	dst.Set(key, v)
+	if volatile {
+		dst.SetExpire(key, at)
+	}
+	serveListWaiters(c, dst, key)
+	c.W.WriteInt(1)
+}
+
+// SWAPDB index1 index2
+func SWAPDB(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'swapdb' command")
+		return
+	}
+	a, err := utils.ParseInt(cmd[0])
+	if err != nil {
+		c.W.WriteError("ERR invalid first DB index")
+		return
+	}
+	b, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError("ERR invalid second DB index")
+		return
+	}
+	n := int64(store.NumDatabases())
+	if a < 0 || a >= n || b < 0 || b >= n {
+		c.W.WriteError("ERR DB index is out of range")
+		return
+	}
+
+	store.SwapDB(int(a), int(b))
+	// Clients blocked in either database may now find their keys.
+	serveAllListWaiters(c)
+	c.W.WriteSimpleString("OK")
+}
+
+// DBSIZE
+func DBSIZE(c *Client, cmd [][]byte) {
+	if len(cmd) != 0 {
+		c.W.WriteError("ERR wrong number of arguments for 'dbsize' command")
+		return
+	}
+	c.W.WriteInt(int64(c.DB().Len()))
+}
+
+// FLUSHDB [ASYNC | SYNC]
+func FLUSHDB(c *Client, cmd [][]byte) {
+	async, ok := parseFlushMode(c, "flushdb", cmd)
+	if !ok {
+		return
+	}
+	c.DB().Flush(async)
+	c.W.WriteSimpleString("OK")
+}
+
+// FLUSHALL [ASYNC | SYNC]
+func FLUSHALL(c *Client, cmd [][]byte) {
+	async, ok := parseFlushMode(c, "flushall", cmd)
+	if !ok {
+		return
+	}
+	store.FlushAll(async)
+	c.W.WriteSimpleString("OK")
+}
+
+func parseFlushMode(c *Client, name string, cmd [][]byte) (async, ok bool) {
+	if len(cmd) > 1 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return false, false
+	}
+	if len(cmd) == 0 {
+		return false, true
+	}
+	switch strings.ToUpper(string(cmd[0])) {
+	case "ASYNC":
+		return true, true
+	case "SYNC":
+		return false, true
+	}
+	c.W.WriteError("ERR syntax error")
+	return false, false
+}
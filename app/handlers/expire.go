@@ -0,0 +1,188 @@
+package handlers
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// EXPIRE key seconds [NX | XX | GT | LT]
+func EXPIRE(c *Client, cmd [][]byte) {
+	expireGeneric(c, cmd, "expire", store.Now(), 1000)
+}
+
+// PEXPIRE key milliseconds [NX | XX | GT | LT]
+func PEXPIRE(c *Client, cmd [][]byte) {
+	expireGeneric(c, cmd, "pexpire", store.Now(), 1)
+}
+
+// EXPIREAT key unix-time-seconds [NX | XX | GT | LT]
+func EXPIREAT(c *Client, cmd [][]byte) {
+	expireGeneric(c, cmd, "expireat", 0, 1000)
+}
+
+// PEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT]
+func PEXPIREAT(c *Client, cmd [][]byte) {
+	expireGeneric(c, cmd, "pexpireat", 0, 1)
+}
+
+// expireGeneric implements the EXPIRE family. The expiry is basetime plus
+// the given time multiplied by unit, both in milliseconds. Like GETEX, it is
+// replicated as a PEXPIREAT of the absolute time, so replicas don't compute
+// a deadline of their own, or as a DEL when the time has already passed.
+// Nothing is replicated when the reply is 0.
+func expireGeneric(c *Client, cmd [][]byte, name string, basetime, unit int64) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	var nx, xx, gt, lt bool
+	for _, opt := range cmd[2:] {
+		switch strings.ToUpper(string(opt)) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "GT":
+			gt = true
+		case "LT":
+			lt = true
+		default:
+			c.W.WriteError("ERR Unsupported option " + string(opt))
+			return
+		}
+	}
+	if nx && (xx || gt || lt) {
+		c.W.WriteError("ERR NX and XX, GT or LT options at the same time are not compatible")
+		return
+	}
+	if gt && lt {
+		c.W.WriteError("ERR GT and LT options at the same time are not compatible")
+		return
+	}
+
+	when, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if when > math.MaxInt64/unit || when < math.MinInt64/unit {
+		c.W.WriteError("ERR invalid expire time in '" + name + "' command")
+		return
+	}
+	when *= unit
+	if (when > 0 && basetime > math.MaxInt64-when) || (when < 0 && basetime < math.MinInt64-when) {
+		c.W.WriteError("ERR invalid expire time in '" + name + "' command")
+		return
+	}
+	when += basetime
+
+	db := c.DB()
+	key := string(cmd[0])
+	if _, ok := db.Lookup(key); !ok {
+		c.W.WriteInt(0)
+		return
+	}
+
+	// A key without a TTL counts as expiring never, i.e. later than any
+	// time GT or LT could compare against.
+	current, hasTTL := db.Expire(key)
+	switch {
+	case nx && hasTTL,
+		xx && !hasTTL,
+		gt && (!hasTTL || when <= current),
+		lt && hasTTL && when >= current:
+		c.W.WriteInt(0)
+		return
+	}
+
+	if when <= store.Now() {
+		db.Delete(key)
+		c.RewriteCommand([][]byte{[]byte("DEL"), cmd[0]})
+	} else {
+		db.SetExpire(key, when)
+		c.RewriteCommand([][]byte{[]byte("PEXPIREAT"), cmd[0],
+			strconv.AppendInt(nil, when, 10)})
+	}
+	c.W.WriteInt(1)
+}
+
+// TTL key
+func TTL(c *Client, cmd [][]byte) {
+	ttlGeneric(c, cmd, "ttl", false, false)
+}
+
+// PTTL key
+func PTTL(c *Client, cmd [][]byte) {
+	ttlGeneric(c, cmd, "pttl", true, false)
+}
+
+// EXPIRETIME key
+func EXPIRETIME(c *Client, cmd [][]byte) {
+	ttlGeneric(c, cmd, "expiretime", false, true)
+}
+
+// PEXPIRETIME key
+func PEXPIRETIME(c *Client, cmd [][]byte) {
+	ttlGeneric(c, cmd, "pexpiretime", true, true)
+}
+
+// ttlGeneric replies -2 for a missing key, -1 for a key without a TTL, and
+// otherwise the remaining time or the absolute expiry, in seconds unless ms
+// is set.
+func ttlGeneric(c *Client, cmd [][]byte, name string, ms, absolute bool) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	if _, ok := db.Lookup(key); !ok {
+		c.W.WriteInt(-2)
+		return
+	}
+
+	at, ok := db.Expire(key)
+	if !ok {
+		c.W.WriteInt(-1)
+		return
+	}
+
+	ttl := at
+	if !absolute {
+		ttl = at - store.Now()
+	}
+	if ttl < 0 {
+		ttl = 0
+	}
+	if ms {
+		c.W.WriteInt(ttl)
+	} else {
+		c.W.WriteInt((ttl + 500) / 1000)
+	}
+}
+
+// PERSIST key
+func PERSIST(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'persist' command")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	if _, ok := db.Lookup(key); !ok {
+		c.W.WriteInt(0)
+		return
+	}
+	if db.Persist(key) {
+		c.W.WriteInt(1)
+	} else {
+		c.W.WriteInt(0)
+	}
+}
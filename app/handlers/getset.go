@@ -1,70 +1,653 @@
 package handlers
 
 import (
-	"fmt"
-	"net"
+	"bytes"
+	"math"
 	"strconv"
 	"strings"
-	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
 )
 
-var redisKeyValueStore = make(map[string]interface{})
-var redisKeyExpiryTime = make(map[string]time.Time)
+// SET key value [NX | XX] [GET] [EX seconds | PX milliseconds |
+// EXAT unix-time-seconds | PXAT unix-time-milliseconds | KEEPTTL]
+func SET(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'set' command")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	value := cmd[1]
 
-func SET(cmdParser []interface{}, conn net.Conn) {
+	var nx, xx, get, keepTTL bool
+	var expireOpt string
+	var expireArg []byte
+	var expireIdx int
+	for i := 2; i < len(cmd); i++ {
+		opt := strings.ToUpper(string(cmd[i]))
+		switch {
+		case opt == "NX" && !xx:
+			nx = true
+		case opt == "XX" && !nx:
+			xx = true
+		case opt == "GET":
+			get = true
+		case opt == "KEEPTTL" && expireOpt == "":
+			keepTTL = true
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") &&
+			expireOpt == "" && !keepTTL && i+1 < len(cmd):
+			expireOpt = opt
+			expireArg = cmd[i+1]
+			expireIdx = i
+			i++
+		default:
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	var expireAt int64
+	if expireOpt != "" {
+		n, err := utils.ParseInt(expireArg)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		var ok bool
+		expireAt, ok = setExpireTime(expireOpt, n)
+		if !ok {
+			c.W.WriteError("ERR invalid expire time in 'set' command")
+			return
+		}
+	}
 
-	key := fmt.Sprintf("%v", cmdParser[0])
-	value := cmdParser[1]
+	old, err := db.String(key)
+	if get && err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	_, exists := db.Lookup(key)
 
-	mu.Lock()
-	redisKeyValueStore[key] = value
+	if (nx && exists) || (xx && !exists) {
+		if get {
+			writeNullableBulk(c, old)
+		} else {
+			c.W.WriteNull()
+		}
+		return
+	}
 
-	if len(cmdParser) > 3 && strings.ToUpper(fmt.Sprintf("%v", cmdParser[2])) == "PX" {
-		ms, _ := strconv.Atoi(fmt.Sprintf("%v", cmdParser[3]))
-		redisKeyExpiryTime[key] = time.Now().Add(time.Duration(ms) * time.Millisecond)
+	if keepTTL {
+		ttl, hasTTL := db.Expire(key)
+		db.Set(key, bytes.Clone(value))
+		if hasTTL {
+			db.SetExpire(key, ttl)
+		}
+	} else {
+		db.Set(key, bytes.Clone(value))
+	}
+	if expireOpt != "" {
+		db.SetExpire(key, expireAt)
+	}
+	// A relative or seconds expire is replicated as PXAT, so replicas don't
+	// work the deadline out from their own clocks.
+	if expireOpt != "" && expireOpt != "PXAT" {
+		argv := append([][]byte{[]byte("SET")}, cmd...)
+		argv[1+expireIdx] = []byte("PXAT")
+		argv[2+expireIdx] = strconv.AppendInt(nil, expireAt, 10)
+		c.RewriteCommand(argv)
+	}
+
+	if get {
+		writeNullableBulk(c, old)
+	} else {
+		c.W.WriteSimpleString("OK")
 	}
-	mu.Unlock()
-	conn.Write([]byte("+OK\r\n"))
 }
 
-func GET(cmd []interface{}, conn net.Conn) {
-	key := fmt.Sprintf("%v", cmd[0])
-	mu.Lock()
+// setExpireTime turns the argument of EX, PX, EXAT or PXAT into a unix time
+// in milliseconds. Like Redis it rejects non-positive and overflowing times.
+func setExpireTime(opt string, n int64) (int64, bool) {
+	if n <= 0 {
+		return 0, false
+	}
+	if opt == "EX" || opt == "EXAT" {
+		if n > math.MaxInt64/1000 {
+			return 0, false
+		}
+		n *= 1000
+	}
+	if opt == "EX" || opt == "PX" {
+		now := store.Now()
+		if n > math.MaxInt64-now {
+			return 0, false
+		}
+		n += now
+	}
+	return n, true
+}
 
-	expiry, ok := redisKeyExpiryTime[key]
-	if ok && expiry.Before(time.Now()) {
-		delete(redisKeyExpiryTime, key)
-		delete(redisKeyValueStore, key)
+// writeNullableBulk writes v, or a null reply when v is nil.
+func writeNullableBulk(c *Client, v []byte) {
+	if v == nil {
+		c.W.WriteNull()
+	} else {
+		c.W.WriteBulk(v)
 	}
+}
 
-	value, ok := redisKeyValueStore[key]
-	mu.Unlock()
+func GET(c *Client, cmd [][]byte) {
+	value, err := c.DB().String(string(cmd[0]))
 
-	if !ok {
-		conn.Write([]byte("$-1\r\n"))
+	if err != nil {
+		c.W.WriteError(err.Error())
 	} else {
-		valStr := fmt.Sprintf("%v", value)
-		fmt.Fprintf(conn, "$%d\r\n%s\r\n", len(valStr), valStr)
+		writeNullableBulk(c, value)
+	}
+}
+
+// maxStringLen is the largest string value a command may create, the
+// default proto-max-bulk-len.
+const maxStringLen = 512 * 1024 * 1024
+
+const errStringTooLong = "ERR string exceeds maximum allowed size (proto-max-bulk-len)"
+
+// APPEND key value
+func APPEND(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'append' command")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	old, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if old == nil {
+		db.Set(key, bytes.Clone(cmd[1]))
+		c.W.WriteInt(int64(len(cmd[1])))
+		return
+	}
+	if len(old)+len(cmd[1]) > maxStringLen {
+		c.W.WriteError(errStringTooLong)
+		return
 	}
+	v := append(old, cmd[1]...)
+	db.Overwrite(key, v)
+	c.W.WriteInt(int64(len(v)))
 }
 
-func INCR(cmd []interface{}, conn net.Conn) {
-	key := fmt.Sprintf("%v", cmd[0])
+// STRLEN key
+func STRLEN(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'strlen' command")
+		return
+	}
+	v, err := c.DB().String(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	c.W.WriteInt(int64(len(v)))
+}
+
+// GETRANGE key start end
+func GETRANGE(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'getrange' command")
+		return
+	}
+	start, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	end, err := utils.ParseInt(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	v, err := c.DB().String(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	n := int64(len(v))
+	if n == 0 || (start < 0 && end < 0 && start > end) {
+		c.W.WriteBulk(nil)
+		return
+	}
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = max(n+end, 0)
+	}
+	end = min(end, n-1)
+	if start > end {
+		c.W.WriteBulk(nil)
+		return
+	}
+	c.W.WriteBulk(v[start : end+1])
+}
 
-	_, ok := redisKeyValueStore[key]
+// SETRANGE key offset value
+func SETRANGE(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'setrange' command")
+		return
+	}
+	offset, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if offset < 0 {
+		c.W.WriteError("ERR offset is out of range")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	value := cmd[2]
+	old, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	// An empty value changes nothing, and doesn't create the key.
+	if len(value) == 0 {
+		c.W.WriteInt(int64(len(old)))
+		return
+	}
+	if offset > maxStringLen-int64(len(value)) {
+		c.W.WriteError(errStringTooLong)
+		return
+	}
+
+	v, _ := growString(db, key, offset+int64(len(value)))
+	copy(v[offset:], value)
+	c.W.WriteInt(int64(len(v)))
+}
+
+// GETSET key value
+func GETSET(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'getset' command")
+		return
+	}
+	db := c.DB()
+	key := string(cmd[0])
+	old, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	db.Set(key, bytes.Clone(cmd[1]))
+	writeNullableBulk(c, old)
+}
+
+// GETDEL key
+func GETDEL(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'getdel' command")
+		return
+	}
+	db := c.DB()
+	key := string(cmd[0])
+	v, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if v != nil {
+		db.Delete(key)
+		c.RewriteCommand([][]byte{[]byte("DEL"), cmd[0]})
+	}
+	writeNullableBulk(c, v)
+}
 
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]
+//
+// Replicas are sent the resulting PEXPIREAT, PERSIST or DEL, so relative
+// times don't drift, and nothing when the TTL is left as it was.
+func GETEX(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'getex' command")
+		return
+	}
+
+	var persist bool
+	var expireOpt string
+	var expireArg []byte
+	for i := 1; i < len(cmd); i++ {
+		opt := strings.ToUpper(string(cmd[i]))
+		switch {
+		case opt == "PERSIST" && expireOpt == "" && !persist:
+			persist = true
+		case (opt == "EX" || opt == "PX" || opt == "EXAT" || opt == "PXAT") &&
+			expireOpt == "" && !persist && i+1 < len(cmd):
+			expireOpt = opt
+			expireArg = cmd[i+1]
+			i++
+		default:
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+	}
+
+	var expireAt int64
+	if expireOpt != "" {
+		n, err := utils.ParseInt(expireArg)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		var ok bool
+		expireAt, ok = setExpireTime(expireOpt, n)
+		if !ok {
+			c.W.WriteError("ERR invalid expire time in 'getex' command")
+			return
+		}
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	v, err := db.String(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if v == nil {
+		c.W.WriteNull()
+		return
+	}
+
+	switch {
+	case expireOpt != "" && expireAt <= store.Now():
+		db.Delete(key)
+		c.RewriteCommand([][]byte{[]byte("DEL"), cmd[0]})
+	case expireOpt != "":
+		db.SetExpire(key, expireAt)
+		c.RewriteCommand([][]byte{[]byte("PEXPIREAT"), cmd[0],
+			strconv.AppendInt(nil, expireAt, 10)})
+	case persist:
+		if db.Persist(key) {
+			c.RewriteCommand([][]byte{[]byte("PERSIST"), cmd[0]})
+		}
+	}
+	c.W.WriteBulk(v)
+}
+
+// SETNX key value
+func SETNX(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'setnx' command")
+		return
+	}
+	db := c.DB()
+	key := string(cmd[0])
+	if _, exists := db.Lookup(key); exists {
+		c.W.WriteInt(0)
+		return
+	}
+	db.Set(key, bytes.Clone(cmd[1]))
+	c.W.WriteInt(1)
+}
+
+// SETEX key seconds value
+func SETEX(c *Client, cmd [][]byte) {
+	setExGeneric(c, cmd, "setex", "EX")
+}
+
+// PSETEX key milliseconds value
+func PSETEX(c *Client, cmd [][]byte) {
+	setExGeneric(c, cmd, "psetex", "PX")
+}
+
+func setExGeneric(c *Client, cmd [][]byte, name, unit string) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	n, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	at, ok := setExpireTime(unit, n)
 	if !ok {
-		redisKeyValueStore[key] = 1
-		fmt.Fprintf(conn, ":%d\r\n", redisKeyValueStore[key])
+		c.W.WriteError("ERR invalid expire time in '" + name + "' command")
+		return
+	}
 
-	} else {
-		switch redisKeyValueStore[key].(type) {
-		case int:
-			redisKeyValueStore[key] = redisKeyValueStore[key].(int) + 1
-			fmt.Fprintf(conn, ":%d\r\n", redisKeyValueStore[key])
+	db := c.DB()
+	key := string(cmd[0])
+	db.Set(key, bytes.Clone(cmd[2]))
+	db.SetExpire(key, at)
+	c.RewriteCommand([][]byte{[]byte("SET"), cmd[0], cmd[2],
+		[]byte("PXAT"), strconv.AppendInt(nil, at, 10)})
+	c.W.WriteSimpleString("OK")
+}
+
+// MGET key [key ...]
+//
+// Keys that are missing or don't hold a string are returned as nulls.
+func MGET(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'mget' command")
+		return
+	}
+	db := c.DB()
+	c.W.WriteArrayLen(len(cmd))
+	for _, k := range cmd {
+		v, _ := db.String(string(k))
+		writeNullableBulk(c, v)
+	}
+}
 
+// MSET key value [key value ...]
+func MSET(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 || len(cmd)%2 != 0 {
+		c.W.WriteError("ERR wrong number of arguments for 'mset' command")
+		return
+	}
+	db := c.DB()
+	for i := 0; i < len(cmd); i += 2 {
+		db.Set(string(cmd[i]), bytes.Clone(cmd[i+1]))
+	}
+	c.W.WriteSimpleString("OK")
+}
+
+// MSETNX key value [key value ...]
+//
+// Sets nothing if any of the keys exists.
+func MSETNX(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 || len(cmd)%2 != 0 {
+		c.W.WriteError("ERR wrong number of arguments for 'msetnx' command")
+		return
+	}
+	db := c.DB()
+	for i := 0; i < len(cmd); i += 2 {
+		if _, exists := db.Lookup(string(cmd[i])); exists {
+			c.W.WriteInt(0)
+			return
+		}
+	}
+	for i := 0; i < len(cmd); i += 2 {
+		db.Set(string(cmd[i]), bytes.Clone(cmd[i+1]))
+	}
+	c.W.WriteInt(1)
+}
+
+// LCS key1 key2 [LEN] [IDX] [MINMATCHLEN min-match-len] [WITHMATCHLEN]
+func LCS(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'lcs' command")
+		return
+	}
+
+	var getLen, getIdx, withMatchLen bool
+	var minMatchLen int64
+	for i := 2; i < len(cmd); i++ {
+		opt := strings.ToUpper(string(cmd[i]))
+		switch {
+		case opt == "LEN":
+			getLen = true
+		case opt == "IDX":
+			getIdx = true
+		case opt == "WITHMATCHLEN":
+			withMatchLen = true
+		case opt == "MINMATCHLEN" && i+1 < len(cmd):
+			n, err := utils.ParseInt(cmd[i+1])
+			if err != nil {
+				c.W.WriteError(err.Error())
+				return
+			}
+			minMatchLen = max(n, 0)
+			i++
 		default:
-			fmt.Fprintf(conn, "-ERR value is not an integer or out of range\r\n")
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+	}
+	if getLen && getIdx {
+		c.W.WriteError("ERR If you want both the length and indexes, please just use IDX.")
+		return
+	}
+
+	db := c.DB()
+	a, errA := db.String(string(cmd[0]))
+	b, errB := db.String(string(cmd[1]))
+	if errA != nil || errB != nil {
+		c.W.WriteError("ERR The specified keys must contain string values")
+		return
+	}
+
+	// The full table is needed to walk back the subsequence. Refuse to
+	// allocate more than a string may hold. LEN needs far less memory but
+	// as much time, so like Redis it gets the same bound.
+	alen, blen := len(a), len(b)
+	if uint64(alen+1)*uint64(blen+1)*4 > maxStringLen {
+		c.W.WriteError("ERR Insufficient memory, transient memory for LCS exceeds proto-max-bulk-len")
+		return
+	}
+	if getLen {
+		c.W.WriteInt(int64(lcsLen(a, b)))
+		return
+	}
+	// dp[j*(alen+1)+i] is the LCS length of a[:i] and b[:j].
+	dp := make([]uint32, (alen+1)*(blen+1))
+	at := func(i, j int) uint32 { return dp[j*(alen+1)+i] }
+	for j := 1; j <= blen; j++ {
+		for i := 1; i <= alen; i++ {
+			if a[i-1] == b[j-1] {
+				dp[j*(alen+1)+i] = at(i-1, j-1) + 1
+			} else {
+				dp[j*(alen+1)+i] = max(at(i-1, j), at(i, j-1))
+			}
+		}
+	}
+
+	// Walk back from the end, collecting the subsequence, or with IDX the
+	// ranges of contiguous matches, last one first.
+	n := int(at(alen, blen))
+	var result []byte
+	if !getIdx {
+		result = make([]byte, n)
+	}
+	type match struct{ aStart, aEnd, bStart, bEnd int }
+	var matches []match
+	aStart, aEnd, bStart, bEnd := alen, 0, 0, 0
+	idx := n
+	for i, j := alen, blen; i > 0 && j > 0; {
+		emit := false
+		if a[i-1] == b[j-1] {
+			if result != nil {
+				result[idx-1] = a[i-1]
+			}
+			if aStart == alen {
+				aStart, aEnd, bStart, bEnd = i-1, i-1, j-1, j-1
+			} else if aStart == i && bStart == j {
+				// Contiguous with the current range: extend it.
+				aStart--
+				bStart--
+			} else {
+				emit = true
+			}
+			// The range can't grow past the start of either string.
+			if aStart == 0 || bStart == 0 {
+				emit = true
+			}
+			idx--
+			i--
+			j--
+		} else {
+			if at(i-1, j) > at(i, j-1) {
+				i--
+			} else {
+				j--
+			}
+			if aStart != alen {
+				emit = true
+			}
+		}
+		if emit {
+			if getIdx && int64(aEnd-aStart+1) >= minMatchLen {
+				matches = append(matches, match{aStart, aEnd, bStart, bEnd})
+			}
+			aStart = alen
+		}
+	}
+
+	if !getIdx {
+		c.W.WriteBulk(result)
+		return
+	}
+	c.W.WriteMapLen(2)
+	c.W.WriteBulkString("matches")
+	c.W.WriteArrayLen(len(matches))
+	for _, m := range matches {
+		if withMatchLen {
+			c.W.WriteArrayLen(3)
+		} else {
+			c.W.WriteArrayLen(2)
+		}
+		c.W.WriteValue([]any{int64(m.aStart), int64(m.aEnd)})
+		c.W.WriteValue([]any{int64(m.bStart), int64(m.bEnd)})
+		if withMatchLen {
+			c.W.WriteInt(int64(m.aEnd - m.aStart + 1))
+		}
+	}
+	c.W.WriteBulkString("len")
+	c.W.WriteInt(int64(n))
+}
+
+// lcsLen returns the length of the longest common subsequence of a and b,
+// keeping only two rows of the table.
+func lcsLen(a, b []byte) uint32 {
+	if len(a) < len(b) {
+		a, b = b, a
+	}
+	prev := make([]uint32, len(b)+1)
+	cur := make([]uint32, len(b)+1)
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			if a[i-1] == b[j-1] {
+				cur[j] = prev[j-1] + 1
+			} else {
+				cur[j] = max(prev[j], cur[j-1])
+			}
 		}
+		prev, cur = cur, prev
 	}
+	return prev[len(b)]
 }
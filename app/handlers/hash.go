@@ -0,0 +1,458 @@
+package handlers
+
+import (
+	"math"
+	"math/rand/v2"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// hashLookupOrCreate returns the hash at key, creating an empty one when the
+// key does not exist.
+func hashLookupOrCreate(db *store.DB, key string) (*store.Hash, error) {
+	h, err := db.Hash(key)
+	if err != nil || h != nil {
+		return h, err
+	}
+	h = store.NewHash()
+	db.Set(key, h)
+	return h, nil
+}
+
+// replicateAs replicates the current command, whose arguments are cmd, as
+// command name. The hash commands replicate themselves this way so that
+// nothing is sent when they fail or change nothing.
+func replicateAs(c *Client, name string, cmd [][]byte) {
+	c.RewriteCommand(append([][]byte{[]byte(name)}, cmd...))
+}
+
+// HSET key field value [field value ...]
+func HSET(c *Client, cmd [][]byte) {
+	if len(cmd) < 3 || len(cmd)%2 == 0 {
+		c.W.WriteError("ERR wrong number of arguments for 'hset' command")
+		return
+	}
+	h, err := hashLookupOrCreate(c.DB(), string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	added := 0
+	for i := 1; i < len(cmd); i += 2 {
+		if h.Set(string(cmd[i]), string(cmd[i+1])) {
+			added++
+		}
+	}
+	replicateAs(c, "HSET", cmd)
+	c.W.WriteInt(int64(added))
+}
+
+// HSETNX key field value
+func HSETNX(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'hsetnx' command")
+		return
+	}
+	h, err := hashLookupOrCreate(c.DB(), string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	field := string(cmd[1])
+	if _, ok := h.Get(field); ok {
+		c.W.WriteInt(0)
+		return
+	}
+	h.Set(field, string(cmd[2]))
+	replicateAs(c, "HSETNX", cmd)
+	c.W.WriteInt(1)
+}
+
+// HGET key field
+func HGET(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'hget' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if h == nil {
+		c.W.WriteNull()
+		return
+	}
+	v, ok := h.Get(string(cmd[1]))
+	if !ok {
+		c.W.WriteNull()
+		return
+	}
+	c.W.WriteBulkString(v)
+}
+
+// HMGET key field [field ...]
+func HMGET(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'hmget' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	c.W.WriteArrayLen(len(cmd) - 1)
+	for _, field := range cmd[1:] {
+		if h == nil {
+			c.W.WriteNull()
+			continue
+		}
+		if v, ok := h.Get(string(field)); ok {
+			c.W.WriteBulkString(v)
+		} else {
+			c.W.WriteNull()
+		}
+	}
+}
+
+// HDEL key field [field ...]
+func HDEL(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'hdel' command")
+		return
+	}
+	db := c.DB()
+	key := string(cmd[0])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if h == nil {
+		c.W.WriteInt(0)
+		return
+	}
+	deleted := 0
+	for _, field := range cmd[1:] {
+		if h.Delete(string(field)) {
+			deleted++
+		}
+	}
+	if h.Len() == 0 {
+		db.Delete(key)
+	}
+	if deleted > 0 {
+		replicateAs(c, "HDEL", cmd)
+	}
+	c.W.WriteInt(int64(deleted))
+}
+
+// HLEN key
+func HLEN(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'hlen' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if h == nil {
+		c.W.WriteInt(0)
+		return
+	}
+	c.W.WriteInt(int64(h.Len()))
+}
+
+// HSTRLEN key field
+func HSTRLEN(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'hstrlen' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	var v string
+	if h != nil {
+		v, _ = h.Get(string(cmd[1]))
+	}
+	c.W.WriteInt(int64(len(v)))
+}
+
+// HEXISTS key field
+func HEXISTS(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'hexists' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	exists := false
+	if h != nil {
+		_, exists = h.Get(string(cmd[1]))
+	}
+	if exists {
+		c.W.WriteInt(1)
+	} else {
+		c.W.WriteInt(0)
+	}
+}
+
+// HGETALL key
+func HGETALL(c *Client, cmd [][]byte) {
+	hashGetAll(c, cmd, "hgetall", true, true)
+}
+
+// HKEYS key
+func HKEYS(c *Client, cmd [][]byte) {
+	hashGetAll(c, cmd, "hkeys", true, false)
+}
+
+// HVALS key
+func HVALS(c *Client, cmd [][]byte) {
+	hashGetAll(c, cmd, "hvals", false, true)
+}
+
+// hashGetAll implements HGETALL, HKEYS and HVALS. HGETALL replies with a map
+// under RESP3.
+func hashGetAll(c *Client, cmd [][]byte, name string, fields, values bool) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if h == nil {
+		h = store.NewHash()
+	}
+	switch {
+	case fields && values && c.W.Proto() >= 3:
+		c.W.WriteMapLen(h.Len())
+	case fields && values:
+		c.W.WriteArrayLen(h.Len() * 2)
+	default:
+		c.W.WriteArrayLen(h.Len())
+	}
+	h.Range(func(field, value string) bool {
+		if fields {
+			c.W.WriteBulkString(field)
+		}
+		if values {
+			c.W.WriteBulkString(value)
+		}
+		return true
+	})
+}
+
+// HINCRBY key field increment
+func HINCRBY(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'hincrby' command")
+		return
+	}
+	incr, err := utils.ParseInt(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	db := c.DB()
+	key, field := string(cmd[0]), string(cmd[1])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	var n int64
+	if h != nil {
+		if v, ok := h.Get(field); ok {
+			n, err = utils.ParseInt([]byte(v))
+			if err != nil {
+				c.W.WriteError("ERR hash value is not an integer")
+				return
+			}
+		}
+	}
+	if (incr > 0 && n > math.MaxInt64-incr) || (incr < 0 && n < math.MinInt64-incr) {
+		c.W.WriteError("ERR increment or decrement would overflow")
+		return
+	}
+	n += incr
+
+	if h == nil {
+		h, _ = hashLookupOrCreate(db, key)
+	}
+	h.Overwrite(field, strconv.FormatInt(n, 10))
+	replicateAs(c, "HINCRBY", cmd)
+	c.W.WriteInt(n)
+}
+
+// HINCRBYFLOAT key field increment
+//
+// Like INCRBYFLOAT, the result is replicated as an HSET so that replicas
+// don't redo the floating point arithmetic. HSET clears the field's TTL, so
+// a TTL is replicated again after it.
+func HINCRBYFLOAT(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'hincrbyfloat' command")
+		return
+	}
+	incr, err := utils.ParseFloat(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	db := c.DB()
+	key, field := string(cmd[0]), string(cmd[1])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	var f float64
+	if h != nil {
+		if v, ok := h.Get(field); ok {
+			f, err = utils.ParseFloat([]byte(v))
+			if err != nil {
+				c.W.WriteError("ERR hash value is not a float")
+				return
+			}
+		}
+	}
+	f += incr
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		c.W.WriteError("ERR increment would produce NaN or Infinity")
+		return
+	}
+
+	out := strconv.AppendFloat(nil, f, 'f', -1, 64)
+	if h == nil {
+		h, _ = hashLookupOrCreate(db, key)
+	}
+	h.Overwrite(field, string(out))
+	c.RewriteCommand([][]byte{[]byte("HSET"), cmd[0], cmd[1], out})
+	if at, ok := h.FieldExpire(field); ok {
+		c.AlsoPropagate(c.DBIndex(), [][]byte{[]byte("HPEXPIREAT"), cmd[0],
+			[]byte(strconv.FormatInt(at, 10)), []byte("FIELDS"), []byte("1"), cmd[1]})
+	}
+	c.W.WriteBulk(out)
+}
+
+// hrandfieldMaxCount bounds a negative HRANDFIELD count. The reply repeats
+// fields to reach -count whatever the size of the hash, and is built in
+// memory while the keyspace is locked, so an unbounded count could stall
+// every client for good.
+const hrandfieldMaxCount = 1 << 20
+
+// HRANDFIELD key [count [WITHVALUES]]
+//
+// A negative count below -hrandfieldMaxCount fails with "ERR value is out
+// of range", where Redis goes down to -LONG_MAX.
+func HRANDFIELD(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 || len(cmd) > 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'hrandfield' command")
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	if len(cmd) == 1 {
+		if h == nil {
+			c.W.WriteNull()
+			return
+		}
+		field, _ := h.Random()
+		c.W.WriteBulkString(field)
+		return
+	}
+
+	count, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if count < -hrandfieldMaxCount {
+		c.W.WriteError("ERR value is out of range")
+		return
+	}
+	withValues := false
+	if len(cmd) == 3 {
+		if !strings.EqualFold(string(cmd[2]), "WITHVALUES") {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		// The reply holds twice count elements.
+		if count > math.MaxInt64/2 {
+			c.W.WriteError("ERR value is out of range")
+			return
+		}
+		withValues = true
+	}
+	if h == nil || count == 0 {
+		c.W.WriteArrayLen(0)
+		return
+	}
+
+	resp3Pairs := withValues && c.W.Proto() >= 3
+	writePick := func(field, value string) {
+		if resp3Pairs {
+			c.W.WriteArrayLen(2)
+		}
+		c.W.WriteBulkString(field)
+		if withValues {
+			c.W.WriteBulkString(value)
+		}
+	}
+	writeLen := func(n int) {
+		if withValues && !resp3Pairs {
+			n *= 2
+		}
+		c.W.WriteArrayLen(n)
+	}
+
+	if count < 0 {
+		// A negative count may return the same field more than once.
+		writeLen(int(-count))
+		for i := int64(0); i < -count; i++ {
+			writePick(h.Random())
+		}
+		return
+	}
+
+	var picked [][2]string
+	h.Range(func(field, value string) bool {
+		picked = append(picked, [2]string{field, value})
+		return true
+	})
+	if count < int64(len(picked)) {
+		// Partial Fisher-Yates shuffle: the first count pairs end up a
+		// uniform sample without repetition.
+		for i := 0; i < int(count); i++ {
+			j := i + rand.IntN(len(picked)-i)
+			picked[i], picked[j] = picked[j], picked[i]
+		}
+		picked = picked[:count]
+	}
+	writeLen(len(picked))
+	for _, p := range picked {
+		writePick(p[0], p[1])
+	}
+}
@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// hashExpireTimeMax is the latest unix time in milliseconds a hash field
+// can expire at, the same bound Redis uses.
+const hashExpireTimeMax = 1<<48 - 1
+
+const errHashExpireTime = "ERR invalid expire time, must be >= 0 and <= 281474976710655"
+
+// parseHashFields parses the FIELDS numfields field [field ...] block that
+// ends the field TTL commands, starting at cmd[i].
+func parseHashFields(c *Client, cmd [][]byte, i int) ([][]byte, bool) {
+	if i >= len(cmd) || !strings.EqualFold(string(cmd[i]), "FIELDS") || i+1 >= len(cmd) {
+		c.W.WriteError("ERR Mandatory argument FIELDS is missing or not at the right position")
+		return nil, false
+	}
+	n, err := utils.ParseInt(cmd[i+1])
+	if err != nil || n <= 0 {
+		c.W.WriteError("ERR Parameter `numFields` should be greater than 0")
+		return nil, false
+	}
+	fields := cmd[i+2:]
+	if int64(len(fields)) != n {
+		c.W.WriteError("ERR The `numfields` parameter must match the number of arguments")
+		return nil, false
+	}
+	return fields, true
+}
+
+// writeHashFieldInts writes one integer per field, all of them -2 when the
+// hash does not exist.
+func writeHashFieldInts(c *Client, fields [][]byte, h *store.Hash, fn func(field string) int64) {
+	c.W.WriteArrayLen(len(fields))
+	for _, field := range fields {
+		if h == nil {
+			c.W.WriteInt(-2)
+			continue
+		}
+		c.W.WriteInt(fn(string(field)))
+	}
+}
+
+// HEXPIRE key seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HEXPIRE(c *Client, cmd [][]byte) {
+	hexpireGeneric(c, cmd, "hexpire", store.Now(), 1000)
+}
+
+// HPEXPIRE key milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HPEXPIRE(c *Client, cmd [][]byte) {
+	hexpireGeneric(c, cmd, "hpexpire", store.Now(), 1)
+}
+
+// HEXPIREAT key unix-time-seconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HEXPIREAT(c *Client, cmd [][]byte) {
+	hexpireGeneric(c, cmd, "hexpireat", 0, 1000)
+}
+
+// HPEXPIREAT key unix-time-milliseconds [NX | XX | GT | LT] FIELDS numfields field [field ...]
+func HPEXPIREAT(c *Client, cmd [][]byte) {
+	hexpireGeneric(c, cmd, "hpexpireat", 0, 1)
+}
+
+// hexpireGeneric implements the HEXPIRE family like expireGeneric does the
+// EXPIRE one. For each field it replies -2 when the field does not exist, 0
+// when the condition is not met, 1 when the TTL was set and 2 when the time
+// has already passed and the field was deleted. Only the fields it changed
+// are replicated, as an HPEXPIREAT of the absolute time and an HDEL of the
+// deleted ones.
+func hexpireGeneric(c *Client, cmd [][]byte, name string, basetime, unit int64) {
+	if len(cmd) < 5 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	var nx, xx, gt, lt bool
+	fieldsAt := 2
+	switch strings.ToUpper(string(cmd[2])) {
+	case "NX":
+		nx = true
+	case "XX":
+		xx = true
+	case "GT":
+		gt = true
+	case "LT":
+		lt = true
+	}
+	if nx || xx || gt || lt {
+		fieldsAt++
+	}
+	fields, ok := parseHashFields(c, cmd, fieldsAt)
+	if !ok {
+		return
+	}
+
+	when, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if when < 0 || when > hashExpireTimeMax/unit {
+		c.W.WriteError(errHashExpireTime)
+		return
+	}
+	when = when*unit + basetime
+	if when > hashExpireTimeMax {
+		c.W.WriteError(errHashExpireTime)
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	now := store.Now()
+	var set, deleted [][]byte
+	writeHashFieldInts(c, fields, h, func(field string) int64 {
+		if _, ok := h.Get(field); !ok {
+			return -2
+		}
+		// A field without a TTL counts as expiring never, as with keys.
+		current, hasTTL := h.FieldExpire(field)
+		switch {
+		case nx && hasTTL,
+			xx && !hasTTL,
+			gt && (!hasTTL || when <= current),
+			lt && hasTTL && when >= current:
+			return 0
+		}
+		if when <= now {
+			h.Delete(field)
+			deleted = append(deleted, []byte(field))
+			return 2
+		}
+		db.SetFieldExpire(key, field, when)
+		set = append(set, []byte(field))
+		return 1
+	})
+	if h != nil && h.Len() == 0 {
+		db.Delete(key)
+	}
+
+	var replicated [][][]byte
+	if len(set) > 0 {
+		argv := [][]byte{[]byte("HPEXPIREAT"), cmd[0], strconv.AppendInt(nil, when, 10),
+			[]byte("FIELDS"), strconv.AppendInt(nil, int64(len(set)), 10)}
+		replicated = append(replicated, append(argv, set...))
+	}
+	if len(deleted) > 0 {
+		replicated = append(replicated, append([][]byte{[]byte("HDEL"), cmd[0]}, deleted...))
+	}
+	for i, argv := range replicated {
+		if i == 0 {
+			c.RewriteCommand(argv)
+		} else {
+			c.AlsoPropagate(c.DBIndex(), argv)
+		}
+	}
+}
+
+// HTTL key FIELDS numfields field [field ...]
+func HTTL(c *Client, cmd [][]byte) {
+	httlGeneric(c, cmd, "httl", false, false)
+}
+
+// HPTTL key FIELDS numfields field [field ...]
+func HPTTL(c *Client, cmd [][]byte) {
+	httlGeneric(c, cmd, "hpttl", true, false)
+}
+
+// HEXPIRETIME key FIELDS numfields field [field ...]
+func HEXPIRETIME(c *Client, cmd [][]byte) {
+	httlGeneric(c, cmd, "hexpiretime", false, true)
+}
+
+// HPEXPIRETIME key FIELDS numfields field [field ...]
+func HPEXPIRETIME(c *Client, cmd [][]byte) {
+	httlGeneric(c, cmd, "hpexpiretime", true, true)
+}
+
+// httlGeneric is ttlGeneric for hash fields: per field it replies -2 for a
+// missing field, -1 for a field without a TTL, and otherwise the remaining
+// time or the absolute expiry, in seconds unless ms is set.
+func httlGeneric(c *Client, cmd [][]byte, name string, ms, absolute bool) {
+	if len(cmd) < 4 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	fields, ok := parseHashFields(c, cmd, 1)
+	if !ok {
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	now := store.Now()
+	writeHashFieldInts(c, fields, h, func(field string) int64 {
+		if _, ok := h.Get(field); !ok {
+			return -2
+		}
+		at, ok := h.FieldExpire(field)
+		if !ok {
+			return -1
+		}
+		ttl := at
+		if !absolute {
+			ttl = max(at-now, 0)
+		}
+		if !ms {
+			// Round the remaining time to the nearest second.
+			ttl = (ttl + 500) / 1000
+		}
+		return ttl
+	})
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func HPERSIST(c *Client, cmd [][]byte) {
+	if len(cmd) < 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'hpersist' command")
+		return
+	}
+	fields, ok := parseHashFields(c, cmd, 1)
+	if !ok {
+		return
+	}
+	h, err := c.DB().Hash(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	var persisted [][]byte
+	writeHashFieldInts(c, fields, h, func(field string) int64 {
+		if _, ok := h.Get(field); !ok {
+			return -2
+		}
+		if !h.PersistField(field) {
+			return -1
+		}
+		persisted = append(persisted, []byte(field))
+		return 1
+	})
+	// Replicate only the fields that had a TTL.
+	if len(persisted) > 0 {
+		argv := [][]byte{[]byte("HPERSIST"), cmd[0], []byte("FIELDS"),
+			strconv.AppendInt(nil, int64(len(persisted)), 10)}
+		c.RewriteCommand(append(argv, persisted...))
+	}
+}
+
+// HGETDEL key FIELDS numfields field [field ...]
+//
+// The fields that existed are replicated as an HDEL.
+func HGETDEL(c *Client, cmd [][]byte) {
+	if len(cmd) < 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'hgetdel' command")
+		return
+	}
+	fields, ok := parseHashFields(c, cmd, 1)
+	if !ok {
+		return
+	}
+	db := c.DB()
+	key := string(cmd[0])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	deleted := [][]byte{[]byte("HDEL"), cmd[0]}
+	c.W.WriteArrayLen(len(fields))
+	for _, field := range fields {
+		if h == nil {
+			c.W.WriteNull()
+			continue
+		}
+		v, ok := h.Get(string(field))
+		if !ok {
+			c.W.WriteNull()
+			continue
+		}
+		c.W.WriteBulkString(v)
+		h.Delete(string(field))
+		deleted = append(deleted, field)
+	}
+	if len(deleted) == 2 {
+		return
+	}
+	if h.Len() == 0 {
+		db.Delete(key)
+	}
+	c.RewriteCommand(deleted)
+}
+
+// HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field [field ...]
+//
+// Like GETEX, the TTL change is replicated as an HPEXPIREAT, HPERSIST or,
+// when the time has already passed, HDEL.
+func HGETEX(c *Client, cmd [][]byte) {
+	if len(cmd) < 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'hgetex' command")
+		return
+	}
+
+	var persist bool
+	var expireOpt string
+	var expireAt int64
+	fieldsAt := 1
+	switch opt := strings.ToUpper(string(cmd[1])); opt {
+	case "PERSIST":
+		persist = true
+		fieldsAt = 2
+	case "EX", "PX", "EXAT", "PXAT":
+		expireOpt = opt
+		fieldsAt = 3
+	}
+	fields, ok := parseHashFields(c, cmd, fieldsAt)
+	if !ok {
+		return
+	}
+	if expireOpt != "" {
+		n, err := utils.ParseInt(cmd[2])
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		expireAt, ok = setExpireTime(expireOpt, n)
+		if !ok || expireAt > hashExpireTimeMax {
+			c.W.WriteError(errHashExpireTime)
+			return
+		}
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	h, err := db.Hash(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	// A time that has already passed deletes the fields.
+	expired := expireOpt != "" && expireAt <= store.Now()
+	var changed [][]byte
+	c.W.WriteArrayLen(len(fields))
+	for _, field := range fields {
+		if h == nil {
+			c.W.WriteNull()
+			continue
+		}
+		v, ok := h.Get(string(field))
+		if !ok {
+			c.W.WriteNull()
+			continue
+		}
+		c.W.WriteBulkString(v)
+		switch {
+		case expired:
+			h.Delete(string(field))
+		case expireOpt != "":
+			db.SetFieldExpire(key, string(field), expireAt)
+		case persist:
+			if !h.PersistField(string(field)) {
+				continue
+			}
+		default:
+			continue
+		}
+		changed = append(changed, field)
+	}
+	if len(changed) == 0 {
+		return
+	}
+
+	var argv [][]byte
+	switch {
+	case expired:
+		if h.Len() == 0 {
+			db.Delete(key)
+		}
+		argv = [][]byte{[]byte("HDEL"), cmd[0]}
+		c.RewriteCommand(append(argv, changed...))
+		return
+	case expireOpt != "":
+		argv = [][]byte{[]byte("HPEXPIREAT"), cmd[0], strconv.AppendInt(nil, expireAt, 10)}
+	default:
+		argv = [][]byte{[]byte("HPERSIST"), cmd[0]}
+	}
+	argv = append(argv, []byte("FIELDS"), strconv.AppendInt(nil, int64(len(changed)), 10))
+	c.RewriteCommand(append(argv, changed...))
+}
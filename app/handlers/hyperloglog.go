@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+)
+
+// hllLookup returns the HyperLogLog at key, or nil when the key is missing.
+func hllLookup(db *store.DB, key string) ([]byte, error) {
+	v, err := db.String(key)
+	if err != nil || v == nil {
+		return nil, err
+	}
+	if err := store.CheckHLL(v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// PFADD key [element [element ...]]
+func PFADD(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'pfadd' command")
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	v, err := hllLookup(db, key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	created := v == nil
+	if created {
+		v = store.NewHLL()
+		db.Set(key, v)
+	}
+
+	v, changed, err := store.HLLAdd(v, cmd[1:])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if changed {
+		db.Overwrite(key, v)
+	}
+	if created || changed {
+		c.W.WriteInt(1)
+	} else {
+		c.W.WriteInt(0)
+	}
+}
+
+// PFCOUNT key [key ...]
+//
+// With several keys the count is of their union.
+func PFCOUNT(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'pfcount' command")
+		return
+	}
+
+	db := c.DB()
+	if len(cmd) == 1 {
+		v, err := hllLookup(db, string(cmd[0]))
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		if v == nil {
+			c.W.WriteInt(0)
+			return
+		}
+		n, err := store.HLLCount(v)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		c.W.WriteInt(n)
+		return
+	}
+
+	var regs store.HLLRegisters
+	for _, k := range cmd {
+		v, err := hllLookup(db, string(k))
+		if err == nil && v != nil {
+			err = store.HLLMerge(&regs, v)
+		}
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+	}
+	c.W.WriteInt(store.HLLCountRegisters(&regs))
+}
+
+// PFMERGE destkey [sourcekey [sourcekey ...]]
+func PFMERGE(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'pfmerge' command")
+		return
+	}
+
+	db := c.DB()
+	var regs store.HLLRegisters
+	// The result stays sparse unless one of the inputs is already dense.
+	dense := false
+	exists := false
+	for i, k := range cmd {
+		v, err := hllLookup(db, string(k))
+		if err == nil && v != nil {
+			err = store.HLLMerge(&regs, v)
+			dense = dense || store.IsDenseHLL(v)
+			exists = exists || i == 0
+		}
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+	}
+
+	dest := string(cmd[0])
+	v := store.HLLFromRegisters(&regs, !dense)
+	if exists {
+		db.Overwrite(dest, v)
+	} else {
+		db.Set(dest, v)
+	}
+	c.W.WriteSimpleString("OK")
+}
@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+func TYPE(c *Client, cmd [][]byte) {
+	v, ok := c.DB().Lookup(string(cmd[0]))
+	if !ok {
+		c.W.WriteSimpleString("none")
+		return
+	}
+	c.W.WriteSimpleString(store.TypeOf(v))
+}
+
+// DEL key [key ...]
+func DEL(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'del' command")
+		return
+	}
+
+	db := c.DB()
+	var deleted int64
+	for _, key := range cmd {
+		if _, ok := db.Lookup(string(key)); ok && db.Delete(string(key)) {
+			deleted++
+		}
+	}
+	c.W.WriteInt(deleted)
+}
+
+// UNLINK key [key ...]
+func UNLINK(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'unlink' command")
+		return
+	}
+
+	db := c.DB()
+	var deleted int64
+	for _, key := range cmd {
+		if _, ok := db.Lookup(string(key)); ok && db.Unlink(string(key)) {
+			deleted++
+		}
+	}
+	c.W.WriteInt(deleted)
+}
+
+// EXISTS key [key ...]
+func EXISTS(c *Client, cmd [][]byte) {
+	existsGeneric(c, cmd, "exists")
+}
+
+// TOUCH key [key ...]
+func TOUCH(c *Client, cmd [][]byte) {
+	existsGeneric(c, cmd, "touch")
+}
+
+// existsGeneric counts the given keys that exist. A key named twice is
+// counted twice.
+func existsGeneric(c *Client, cmd [][]byte, name string) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+
+	db := c.DB()
+	var count int64
+	for _, key := range cmd {
+		if _, ok := db.Lookup(string(key)); ok {
+			count++
+		}
+	}
+	c.W.WriteInt(count)
+}
+
+// RENAME key newkey
+func RENAME(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'rename' command")
+		return
+	}
+
+	db := c.DB()
+	src, dst := string(cmd[0]), string(cmd[1])
+	if _, ok := db.Lookup(src); !ok {
+		c.W.WriteError("ERR no such key")
+		return
+	}
+	if src != dst {
+		db.Rename(src, dst)
+		serveListWaiters(c, db, dst)
+	}
+	c.W.WriteSimpleString("OK")
+}
+
+// RENAMENX key newkey
+func RENAMENX(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'renamenx' command")
+		return
+	}
+
+	db := c.DB()
+	src, dst := string(cmd[0]), string(cmd[1])
+	if _, ok := db.Lookup(src); !ok {
+		c.W.WriteError("ERR no such key")
+		return
+	}
+	if _, ok := db.Lookup(dst); ok {
+		c.W.WriteInt(0)
+		return
+	}
+	db.Rename(src, dst)
+	serveListWaiters(c, db, dst)
+	c.W.WriteInt(1)
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func COPY(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'copy' command")
+		return
+	}
+
+	src, dst := string(cmd[0]), string(cmd[1])
+	var replace bool
+	dstDB := int64(c.DBIndex())
+	for i := 2; i < len(cmd); i++ {
+		switch opt := strings.ToUpper(string(cmd[i])); {
+		case opt == "REPLACE":
+			replace = true
+		case opt == "DB" && i+1 < len(cmd):
+			n, err := utils.ParseInt(cmd[i+1])
+			if err != nil {
+				c.W.WriteError(err.Error())
+				return
+			}
+			dstDB = n
+			i++
+		default:
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+	}
+	if dstDB < 0 || dstDB >= int64(store.NumDatabases()) {
+		c.W.WriteError("ERR DB index is out of range")
+		return
+	}
+	db, to := c.DB(), store.Select(int(dstDB))
+	if src == dst && db == to {
+		c.W.WriteError("ERR source and destination objects are the same")
+		return
+	}
+
+	v, ok := db.Lookup(src)
+	if !ok {
+		c.W.WriteInt(0)
+		return
+	}
+	if _, exists := to.Lookup(dst); exists && !replace {
+		c.W.WriteInt(0)
+		return
+	}
+
+	to.Set(dst, store.Copy(v))
+	if at, ok := db.Expire(src); ok {
+		to.SetExpire(dst, at)
+	}
+	serveListWaiters(c, to, dst)
+	c.W.WriteInt(1)
+}
+
+// KEYS pattern
+func KEYS(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'keys' command")
+		return
+	}
+
+	db := c.DB()
+	pattern := cmd[0]
+	all := len(pattern) == 1 && pattern[0] == '*'
+
+	var res []string
+	for _, key := range db.Keys() {
+		if !all && !utils.GlobMatch(pattern, []byte(key), false) {
+			continue
+		}
+		if _, ok := db.Lookup(key); ok {
+			res = append(res, key)
+		}
+	}
+	c.W.WriteValue(res)
+}
+
+// SCAN cursor [MATCH pattern] [COUNT count] [TYPE type]
+func SCAN(c *Client, cmd [][]byte) {
+	if len(cmd) < 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'scan' command")
+		return
+	}
+
+	cursor, err := strconv.ParseUint(string(cmd[0]), 10, 64)
+	if err != nil {
+		c.W.WriteError("ERR invalid cursor")
+		return
+	}
+
+	var pattern []byte
+	var typ string
+	count := int64(10)
+	for i := 1; i < len(cmd); i++ {
+		opt := strings.ToUpper(string(cmd[i]))
+		if i+1 >= len(cmd) {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		switch opt {
+		case "MATCH":
+			pattern = cmd[i+1]
+		case "COUNT":
+			count, err = utils.ParseInt(cmd[i+1])
+			if err != nil {
+				c.W.WriteError(err.Error())
+				return
+			}
+			if count < 1 {
+				c.W.WriteError("ERR syntax error")
+				return
+			}
+		case "TYPE":
+			typ = strings.ToLower(string(cmd[i+1]))
+		default:
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		i++
+	}
+
+	// Visit buckets until we have COUNT keys, giving up after ten times as
+	// many buckets so a sparse table does not make one call walk all of it.
+	db := c.DB()
+	var keys []string
+	maxIterations := count * 10
+	for {
+		cursor = db.Scan(cursor, func(key string, _ any) {
+			keys = append(keys, key)
+		})
+		maxIterations--
+		if cursor == 0 || maxIterations == 0 || int64(len(keys)) >= count {
+			break
+		}
+	}
+
+	res := keys[:0]
+	for _, key := range keys {
+		if pattern != nil && !utils.GlobMatch(pattern, []byte(key), false) {
+			continue
+		}
+		v, ok := db.Lookup(key)
+		if !ok {
+			continue
+		}
+		if typ != "" && store.TypeOf(v) != typ {
+			continue
+		}
+		res = append(res, key)
+	}
+
+	c.W.WriteArrayLen(2)
+	c.W.WriteBulkString(strconv.FormatUint(cursor, 10))
+	c.W.WriteValue(res)
+}
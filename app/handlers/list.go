@@ -1,72 +1,75 @@
 package handlers
 
 import (
-	"fmt"
-	"strconv"
-	"sync"
-	"time"
-)
-
-type ListWaiters struct {
-	mu      sync.Mutex
-	waiters map[string][]chan string
-}
+	"math"
+	"strings"
 
-var listWaiters = ListWaiters{
-	waiters: make(map[string][]chan string),
-}
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
 
-var mu sync.RWMutex
-var RedisListStore = map[string][]string{}
+func RPUSH(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'rpush' command")
+		return
+	}
 
-func RPUSH(cmd []interface{}) (int, error) {
-	key := fmt.Sprintf("%v", cmd[0])
+	db := c.DB()
+	key := string(cmd[0])
 	values := cmd[1:]
 
-	mu.Lock()
+	list, err := db.List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		list = store.NewList()
+		db.Set(key, list)
+	}
 	for _, v := range values {
-		RedisListStore[key] = append(RedisListStore[key], fmt.Sprintf("%v", v))
+		list.PushBack(string(v))
 	}
-	newLen := len(RedisListStore[key])
-	mu.Unlock()
+	newLen := list.Len()
 
-	// Wake up as many waiters as possible
-	listWaiters.mu.Lock()
-	chans := listWaiters.waiters[key]
-	for len(RedisListStore[key]) > 0 && len(chans) > 0 {
-		val := RedisListStore[key][0]
-		RedisListStore[key] = RedisListStore[key][1:]
-
-		ch := chans[0]
-		chans = chans[1:]
-		go func(v string, c chan string) { c <- v }(val, ch)
-	}
-	listWaiters.waiters[key] = chans
-	listWaiters.mu.Unlock()
+	serveListWaiters(c, db, key)
 
-	return newLen, nil
+	c.W.WriteInt(int64(newLen))
 }
 
-func LRANGE(cmd []interface{}) ([]string, error) {
+func LRANGE(c *Client, cmd [][]byte) {
 	if len(cmd) < 3 {
-		return nil, fmt.Errorf("wrong number of arguments")
+		c.W.WriteError("ERR wrong number of arguments for 'lrange' command")
+		return
 	}
 
-	key := fmt.Sprintf("%v", cmd[0])
-	start, _ := strconv.Atoi(fmt.Sprintf("%v", cmd[1]))
-	end, _ := strconv.Atoi(fmt.Sprintf("%v", cmd[2]))
+	key := string(cmd[0])
+	start64, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	end64, err := utils.ParseInt(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	start, end := int(start64), int(end64)
 
-	mu.RLock()
-	defer mu.RUnlock()
-	list, ok := RedisListStore[key]
-	if !ok {
-		return []string{}, nil
+	list, err := c.DB().List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteArrayLen(0)
+		return
 	}
 
-	length := len(list)
+	length := list.Len()
 
 	if end < 0 {
-		end = len(list) + end
+		end = length + end
 	}
 
 	if start < 0 {
@@ -80,103 +83,447 @@ func LRANGE(cmd []interface{}) ([]string, error) {
 		end = length - 1
 	}
 
-	return list[start : end+1], nil
+	if start > end {
+		c.W.WriteArrayLen(0)
+		return
+	}
+
+	c.W.WriteValue(list.Range(start, end))
 }
 
-func LPUSH(cmd []interface{}) (int, error) {
+func LPUSH(c *Client, cmd [][]byte) {
 	if len(cmd) < 2 {
-		return 0, fmt.Errorf("wrong number of arguments")
+		c.W.WriteError("ERR wrong number of arguments for 'lpush' command")
+		return
 	}
 
-
-	key := fmt.Sprintf("%v", cmd[0])
+	db := c.DB()
+	key := string(cmd[0])
 	values := cmd[1:]
 
-	mu.Lock()
-	defer mu.Unlock()
+	list, err := db.List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		list = store.NewList()
+		db.Set(key, list)
+	}
 	for _, v := range values {
-		RedisListStore[key] = append([]string{fmt.Sprintf("%v", v)}, RedisListStore[key]...)
+		list.PushFront(string(v))
 	}
+	newLen := list.Len()
 
-	return len(RedisListStore[key]), nil
+	serveListWaiters(c, db, key)
 
+	c.W.WriteInt(int64(newLen))
 }
 
-func LLEN(cmd []interface{}) int {
-	key := cmd[0].(string)
+func LLEN(c *Client, cmd [][]byte) {
+	if len(cmd) != 1 {
+		c.W.WriteError("ERR wrong number of arguments for 'llen' command")
+		return
+	}
 
-	val, ok := RedisListStore[key]
+	list, err := c.DB().List(string(cmd[0]))
 
-	if !ok {
-		return 0
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteInt(0)
+		return
 	}
 
-	return len(val)
+	c.W.WriteInt(int64(list.Len()))
+}
+
+// LPOP key [count]
+func LPOP(c *Client, cmd [][]byte) {
+	popGeneric(c, cmd, "lpop", false)
+}
+
+// RPOP key [count]
+func RPOP(c *Client, cmd [][]byte) {
+	popGeneric(c, cmd, "rpop", true)
 }
 
-func LPOP(cmd []interface{}) ([]string, bool) {
-	if len(cmd) < 1 {
-		return nil, false
+// popGeneric pops from the head of the list, or the tail when back is set.
+// With a count the reply is an array, even of one element.
+func popGeneric(c *Client, cmd [][]byte, name string, back bool) {
+	if len(cmd) < 1 || len(cmd) > 2 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
 	}
 
-	key := fmt.Sprintf("%v", cmd[0])
+	db := c.DB()
+	key := string(cmd[0])
 	loop := 1
 	if len(cmd) == 2 {
-		loop = cmd[1].(int)
+		n, err := utils.ParseInt(cmd[1])
+		if err != nil || n < 0 {
+			c.W.WriteError("ERR value is out of range, must be positive")
+			return
+		}
+		loop = int(min(n, math.MaxInt32))
+	}
+
+	list, err := db.List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil || list.Len() == 0 {
+		if len(cmd) == 2 {
+			c.W.WriteNullArray()
+		} else {
+			c.W.WriteNull()
+		}
+		return
+	}
+
+	if loop > list.Len() {
+		loop = list.Len()
+	}
+
+	res := make([]string, 0, loop)
+	for i := 0; i < loop; i++ {
+		var v string
+		if back {
+			v, _ = list.PopBack()
+		} else {
+			v, _ = list.PopFront()
+		}
+		res = append(res, v)
+	}
+	if list.Len() == 0 {
+		db.Delete(key)
+	}
+
+	if len(cmd) == 2 {
+		c.W.WriteValue(res)
+	} else {
+		c.W.WriteBulkString(res[0])
+	}
+}
+
+// listIndex turns a possibly negative index into an offset into a list of
+// length n, reporting whether it falls inside the list.
+func listIndex(i int64, n int) (int, bool) {
+	if i < 0 {
+		i += int64(n)
+	}
+	if i < 0 || i >= int64(n) {
+		return 0, false
+	}
+	return int(i), true
+}
+
+// LINDEX key index
+func LINDEX(c *Client, cmd [][]byte) {
+	if len(cmd) != 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'lindex' command")
+		return
+	}
+	index, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	list, err := c.DB().List(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteNull()
+		return
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		c.W.WriteNull()
+		return
 	}
+	c.W.WriteBulkString(list.Index(i))
+}
 
-	mu.Lock()
-	defer mu.Unlock()
+// LSET key index element
+func LSET(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'lset' command")
+		return
+	}
+	index, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	list, err := c.DB().List(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteError("ERR no such key")
+		return
+	}
+	i, ok := listIndex(index, list.Len())
+	if !ok {
+		c.W.WriteError("ERR index out of range")
+		return
+	}
+	list.SetIndex(i, string(cmd[2]))
+	c.W.WriteSimpleString("OK")
+}
 
-	list, ok := RedisListStore[key]
-	if !ok || len(list) == 0 {
-		return nil, false
+// LINSERT key BEFORE | AFTER pivot element
+func LINSERT(c *Client, cmd [][]byte) {
+	if len(cmd) != 4 {
+		c.W.WriteError("ERR wrong number of arguments for 'linsert' command")
+		return
+	}
+	var after bool
+	switch strings.ToUpper(string(cmd[1])) {
+	case "BEFORE":
+	case "AFTER":
+		after = true
+	default:
+		c.W.WriteError("ERR syntax error")
+		return
 	}
 
-	if loop > len(list) {
-		loop = len(list)
+	list, err := c.DB().List(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
 	}
+	if list == nil {
+		c.W.WriteInt(0)
+		return
+	}
+	pivot := string(cmd[2])
+	at := -1
+	list.Iterate(0, false, func(i int, v string) bool {
+		if v != pivot {
+			return true
+		}
+		at = i
+		return false
+	})
+	if at < 0 {
+		c.W.WriteInt(-1)
+		return
+	}
+	if after {
+		at++
+	}
+	list.Insert(at, string(cmd[3]))
+	c.W.WriteInt(int64(list.Len()))
+}
 
-	res := list[:loop]
+// LREM key count element
+func LREM(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'lrem' command")
+		return
+	}
+	count, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	list, err := db.List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteInt(0)
+		return
+	}
+	count = max(min(count, math.MaxInt32), -math.MaxInt32)
+	removed := list.Remove(string(cmd[2]), int(count))
+	if list.Len() == 0 {
+		db.Delete(key)
+	}
+	c.W.WriteInt(int64(removed))
+}
+
+// LTRIM key start stop
+func LTRIM(c *Client, cmd [][]byte) {
+	if len(cmd) != 3 {
+		c.W.WriteError("ERR wrong number of arguments for 'ltrim' command")
+		return
+	}
+	start, err := utils.ParseInt(cmd[1])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	end, err := utils.ParseInt(cmd[2])
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+
+	db := c.DB()
+	key := string(cmd[0])
+	list, err := db.List(key)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteSimpleString("OK")
+		return
+	}
 
-	RedisListStore[key] = list[loop:]
-	return res, true
+	n := int64(list.Len())
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = n + end
+	}
+	if start > end || start >= n {
+		// Nothing is left.
+		db.Delete(key)
+	} else {
+		list.Trim(int(start), int(min(end, n-1)))
+	}
+	c.W.WriteSimpleString("OK")
 }
 
-func BLPOP(cmd []interface{}) (string, bool) {
-	mu.Lock()
-	key := cmd[0].(string)
-	timeoutStr := fmt.Sprintf("%v", cmd[1])
-	timeoutSec, _ := strconv.ParseFloat(timeoutStr, 64)
+// LPOS key element [RANK rank] [COUNT num-matches] [MAXLEN len]
+func LPOS(c *Client, cmd [][]byte) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for 'lpos' command")
+		return
+	}
 
-	if _, ok := RedisListStore[key]; !ok {
-		RedisListStore[key] = []string{}
+	rank, count, maxLen := int64(1), int64(-1), int64(0)
+	for i := 2; i < len(cmd); i += 2 {
+		opt := strings.ToUpper(string(cmd[i]))
+		if i+1 >= len(cmd) || (opt != "RANK" && opt != "COUNT" && opt != "MAXLEN") {
+			c.W.WriteError("ERR syntax error")
+			return
+		}
+		n, err := utils.ParseInt(cmd[i+1])
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
+		switch opt {
+		case "RANK":
+			if n == 0 || n == math.MinInt64 {
+				c.W.WriteError("ERR RANK can't be zero: use 1 to start from the first match, 2 from the second ... or use negative to start from the end of the list")
+				return
+			}
+			rank = n
+		case "COUNT":
+			if n < 0 {
+				c.W.WriteError("ERR COUNT can't be negative")
+				return
+			}
+			count = n
+		case "MAXLEN":
+			if n < 0 {
+				c.W.WriteError("ERR MAXLEN can't be negative")
+				return
+			}
+			maxLen = n
+		}
 	}
 
-	if len(RedisListStore[key]) > 0 {
-		val := RedisListStore[key][0]
-		RedisListStore[key] = RedisListStore[key][1:]
-		mu.Unlock()
-		return val, true
+	list, err := c.DB().List(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
 	}
 
-	mu.Unlock()
+	// Without COUNT only the first match is wanted, as a single reply.
+	want := count
+	if want == 0 {
+		want = math.MaxInt64
+	} else if want < 0 {
+		want = 1
+	}
 
-	ch := make(chan string, 1)
-	listWaiters.mu.Lock()
-	listWaiters.waiters[key] = append(listWaiters.waiters[key], ch)
-	listWaiters.mu.Unlock()
+	var matches []any
+	if list != nil && list.Len() > 0 {
+		elem := string(cmd[1])
+		skip := max(rank, -rank) - 1
+		start := 0
+		if rank < 0 {
+			start = list.Len() - 1
+		}
+		var scanned int64
+		list.Iterate(start, rank < 0, func(i int, v string) bool {
+			if maxLen != 0 && scanned >= maxLen {
+				return false
+			}
+			scanned++
+			if v != elem {
+				return true
+			}
+			if skip > 0 {
+				skip--
+				return true
+			}
+			matches = append(matches, int64(i))
+			return int64(len(matches)) < want
+		})
+	}
 
-	if timeoutSec == 0 {
-		val := <-ch
-		return val, true
+	if count >= 0 {
+		c.W.WriteValue(matches)
+	} else if len(matches) == 0 {
+		c.W.WriteNull()
 	} else {
-		select {
-		case val := <-ch:
-			return val, true
-		case <-time.After(time.Duration(timeoutSec * float64(time.Second))):
-			return "", false
+		c.W.WriteValue(matches[0])
+	}
+}
+
+// LPUSHX key element [element ...]
+func LPUSHX(c *Client, cmd [][]byte) {
+	pushxGeneric(c, cmd, "lpushx", false)
+}
+
+// RPUSHX key element [element ...]
+func RPUSHX(c *Client, cmd [][]byte) {
+	pushxGeneric(c, cmd, "rpushx", true)
+}
+
+// pushxGeneric pushes only onto a list that already exists.
+func pushxGeneric(c *Client, cmd [][]byte, name string, back bool) {
+	if len(cmd) < 2 {
+		c.W.WriteError("ERR wrong number of arguments for '" + name + "' command")
+		return
+	}
+	list, err := c.DB().List(string(cmd[0]))
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	if list == nil {
+		c.W.WriteInt(0)
+		return
+	}
+	for _, v := range cmd[1:] {
+		if back {
+			list.PushBack(string(v))
+		} else {
+			list.PushFront(string(v))
 		}
 	}
+	newLen := list.Len()
+
+	serveListWaiters(c, c.DB(), string(cmd[0]))
 
+	c.W.WriteInt(int64(newLen))
 }
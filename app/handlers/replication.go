@@ -3,28 +3,80 @@ package handlers
 import (
 	"encoding/hex"
 	"fmt"
-	"net"
 	"os"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/store"
 )
 
-func INFO(conn net.Conn, cmd []interface{}) {
-	role := "master"
+// emptyRDB is an RDB file holding no keys, sent to replicas on full resync.
+var emptyRDB, _ = hex.DecodeString("524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2")
+
+// role reports "master", or "slave" when started with --replicaof.
+func role() string {
 	for i := 0; i < len(os.Args); i++ {
 		if os.Args[i] == "--replicaof" {
-			role = "slave"
+			return "slave"
+		}
+	}
+	return "master"
+}
+
+// INFO [section ...]
+func INFO(c *Client, cmd [][]byte) {
+	want := map[string]bool{}
+	for _, s := range cmd[1:] {
+		want[strings.ToLower(string(s))] = true
+	}
+	all := len(want) == 0 || want["all"] || want["default"] || want["everything"]
+
+	var sections []string
+	if all || want["memory"] {
+		sections = append(sections, fmt.Sprintf("# Memory\r\n"+
+			"lazyfree_pending_objects:%d\r\n"+
+			"lazyfreed_objects:%d\r\n",
+			store.LazyfreePending(),
+			store.Lazyfreed()))
+	}
+	if all || want["stats"] {
+		sections = append(sections, fmt.Sprintf("# Stats\r\n"+
+			"expired_keys:%d\r\n"+
+			"expired_subkeys:%d\r\n"+
+			"expired_stale_perc:%.2f\r\n"+
+			"expired_time_cap_reached_count:%d\r\n"+
+			"expire_cycle_cpu_milliseconds:%d\r\n",
+			store.Stats.ExpiredKeys,
+			store.Stats.ExpiredSubkeys,
+			store.Stats.ExpiredStalePerc*100,
+			store.Stats.ExpiredTimeCapReachedCount,
+			store.Stats.ExpireCycleCPUMilliseconds))
+	}
+	if all || want["replication"] {
+		masterReplId := "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
+		masterReplOffset := "0"
+		sections = append(sections, "# Replication\r\n"+
+			"role:"+role()+"\r\n"+
+			"master_replid:"+masterReplId+"\r\n"+
+			"master_repl_offset:"+masterReplOffset+"\r\n")
+	}
+	if all || want["keyspace"] {
+		var b strings.Builder
+		b.WriteString("# Keyspace\r\n")
+		for i := 0; i < store.NumDatabases(); i++ {
+			db := store.Select(i)
+			if db.Len() == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "db%d:keys=%d,expires=%d,avg_ttl=%d\r\n",
+				i, db.Len(), db.ExpiresLen(), db.AvgTTL())
 		}
+		sections = append(sections, b.String())
 	}
-	masterReplId := "8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb"
-	masterReplOffset := "0"
-	response := "role:" + role + "\r\n" +
-		"master_replid:" + masterReplId + "\r\n" +
-		"master_repl_offset:" + masterReplOffset + "\r\n"
-	bulk := fmt.Sprintf("$%d\r\n%s\r\n", len(response), response)
-	fmt.Fprint(conn, bulk)
+
+	c.W.WriteVerbatim("txt", strings.Join(sections, "\r\n"))
 }
 
-func PSYNC(conn net.Conn) {
-	conn.Write([]byte("+FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n"))
-	RDBcontent, _ := hex.DecodeString("524544495330303131fa...")
-	fmt.Fprintf(conn, "$%v\r\n%v", len(RDBcontent), string(RDBcontent))
+func PSYNC(c *Client) {
+	c.W.WriteSimpleString("FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0")
+	c.W.WriteRDB(emptyRDB)
 }
@@ -2,110 +2,100 @@ package handlers
 
 import (
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
-)
-
-var streamTimeAndSeq = map[string]string{}
-var redisStreamKeyWithTimeAndSequence = map[string]string{}
-
-type StreamEntry struct {
-	ID     string
-	Fields map[string]string
-}
 
-type ParentStreamEntry struct {
-	res []StreamEntry
-}
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
 
 type Waiter struct {
 	seq string // last seen ID for this client
-	ch  chan StreamEntry
+	ch  chan store.StreamEntry
 }
 
 type ListWaitersStream struct {
-	mu      sync.Mutex
-	waiters map[string][]Waiter
+	waiters map[waitKey][]Waiter
 }
 
 var listWaitersStream = ListWaitersStream{
-	waiters: make(map[string][]Waiter),
+	waiters: make(map[waitKey][]Waiter),
 }
 
-var redisStreams = map[string][]StreamEntry{}
+func XADD(c *Client, cmd [][]byte) {
+	db := c.DB()
+	streamKey := string(cmd[0])
+	id := string(cmd[1])
 
-func XADD(cmd []interface{}) (string, error) {
-	streamKey := fmt.Sprintf("%v", cmd[0])
-	id := fmt.Sprintf("%v", cmd[1])
+	stream, err := db.Stream(streamKey)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
+	exists := stream != nil
+	if !exists {
+		stream = store.NewStream()
+	}
 
 	fields := map[string]string{}
 	check := true
 
 	if id == "*" {
 		check = false
-		id = handleTimeAndSeq(streamKey)
+		id = handleTimeAndSeq(stream.LastID)
 
 	} else if strings.HasSuffix(id, "-*") {
-		id = handleSeq(id, streamKey)
-		check = false
+		// Still checked: the time part may be below the top item's.
+		id = handleSeq(id, stream.LastID)
 	} else if id == "0-0" {
-		return "", fmt.Errorf("ERR The ID specified in XADD must be greater than 0-0")
+		c.W.WriteError("ERR The ID specified in XADD must be greater than 0-0")
+		return
 	}
 
 	if check {
-		if !isValidID(streamKey, id) {
-			return "", fmt.Errorf("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+		if !isValidID(stream.LastID, id) {
+			c.W.WriteError("ERR The ID specified in XADD is equal or smaller than the target stream top item")
+			return
 		}
 	}
 
 	for i := 2; i < len(cmd); i += 2 {
-		key := fmt.Sprintf("%v", cmd[i])
+		key := string(cmd[i])
 		if i+1 < len(cmd) {
-			fields[key] = fmt.Sprintf("%v", cmd[i+1])
+			fields[key] = string(cmd[i+1])
 		}
 	}
 
-	entry := StreamEntry{
+	entry := store.StreamEntry{
 		ID:     id,
 		Fields: fields,
 	}
-	redisStreams[streamKey] = append(redisStreams[streamKey], entry)
-
-	redisStreamKeyWithTimeAndSequence[streamKey] = id
+	stream.Entries = append(stream.Entries, entry)
+	stream.LastID = id
+	if !exists {
+		db.Set(streamKey, stream)
+	}
+	// Replicas get the ID picked here: with "*" they would pick their own.
+	argv := [][]byte{[]byte("XADD"), cmd[0], []byte(id)}
+	c.RewriteCommand(append(argv, cmd[2:]...))
 
-	listWaitersStream.mu.Lock()
-	chans, ok := listWaitersStream.waiters[streamKey]
+	wk := waitKey{db.ID, streamKey}
+	chans, ok := listWaitersStream.waiters[wk]
 	if ok && len(chans) > 0 {
 
 		ch := chans[0]
 
 		if xreadIsValidId(ch.seq, id) {
-
-			go func() {
-				ch.ch <- StreamEntry{
-					ID:     id,
-					Fields: fields,
-				}
-			}()
-
+			ch.ch <- entry
 		}
-		listWaitersStream.waiters[streamKey] = listWaitersStream.waiters[streamKey][1:]
-
-		listWaitersStream.mu.Unlock()
-
-	} else {
-		listWaitersStream.mu.Unlock()
-
+		listWaitersStream.waiters[wk] = listWaitersStream.waiters[wk][1:]
 	}
 
-	return id, nil
+	c.W.WriteBulkString(id)
 }
 
-func isValidID(streamKey, newID string) bool {
-	lastID := redisStreamKeyWithTimeAndSequence[streamKey]
+func isValidID(lastID, newID string) bool {
 	if lastID == "" {
 		lastID = "0-0"
 	}
@@ -136,10 +126,9 @@ func isValidID(streamKey, newID string) bool {
 	return true
 }
 
-func handleSeq(id, key string) string {
+func handleSeq(id, lastID string) string {
 	ms := strings.Split(id, "-")[0]
 
-	lastID := redisStreamKeyWithTimeAndSequence[key]
 	if lastID == "" {
 		if ms == "0" {
 			return "0-1"
@@ -160,10 +149,9 @@ func handleSeq(id, key string) string {
 	return fmt.Sprintf("%s-0", ms)
 }
 
-func handleTimeAndSeq(key string) string {
+func handleTimeAndSeq(lastID string) string {
 	ms := time.Now().UnixMilli()
 
-	lastID := redisStreamKeyWithTimeAndSequence[key]
 	if lastID == "" {
 		return fmt.Sprintf("%d-0", ms)
 	}
@@ -181,51 +169,68 @@ func handleTimeAndSeq(key string) string {
 	return fmt.Sprintf("%d-0", ms)
 }
 
-func XRANGE(conn net.Conn, cmd []interface{}) {
+func XRANGE(c *Client, cmd [][]byte) {
 
-	streamKey := fmt.Sprintf("%s", cmd[0])
-	startSeq := fmt.Sprintf("%s", cmd[1])
-	endSeq := fmt.Sprintf("%s", cmd[2])
+	streamKey := string(cmd[0])
+	startSeq := string(cmd[1])
+	endSeq := string(cmd[2])
 
 	if endSeq == "+" {
 		endSeq = "999999999999-999999999"
 	}
 
-	entries, ok := redisStreams[streamKey]
+	stream, err := c.DB().Stream(streamKey)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
 
-	if !ok || len(entries) == 0 {
-		conn.Write([]byte("No data availabe"))
+	if stream == nil || len(stream.Entries) == 0 {
+		c.W.WriteArrayLen(0)
 		return
 	}
 
-	var res []StreamEntry
+	var res []store.StreamEntry
 
-	for _, e := range entries {
+	for _, e := range stream.Entries {
 		if xrangeIsValidId(startSeq, endSeq, e.ID) {
 			res = append(res, e)
 		}
 	}
 
-	var s strings.Builder
-
-	s.Write([]byte(fmt.Sprintf("*%d\r\n", len(res))))
-	for _, c := range res {
-		s.Write([]byte(fmt.Sprintf("*%d\r\n", 2)))
-
-		s.Write([]byte(fmt.Sprintf("$%d\r\n%s\r\n", len(c.ID), c.ID)))
-		s.Write([]byte(fmt.Sprintf("*%d\r\n", len(c.Fields)*2)))
-
-		for key := range c.Fields {
-			val := c.Fields[key]
+	writeStreamEntries(c.W, res)
+}
 
-			s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(key), key))
-			s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(val), val))
+// writeStreamEntries writes entries as [id, fields] pairs. Under RESP3 the
+// fields are sent as a map.
+func writeStreamEntries(w *utils.Writer, entries []store.StreamEntry) {
+	w.WriteArrayLen(len(entries))
+	for _, e := range entries {
+		w.WriteArrayLen(2)
+		w.WriteBulkString(e.ID)
+		w.WriteMapLen(len(e.Fields))
+		for k, v := range e.Fields {
+			w.WriteBulkString(k)
+			w.WriteBulkString(v)
 		}
-
 	}
+}
 
-	conn.Write([]byte(s.String()))
-
+// writeStreamsReply writes the XREAD reply: a map of stream name to entries
+// under RESP3, an array of [name, entries] pairs under RESP2.
+func writeStreamsReply(w *utils.Writer, names []string, results [][]store.StreamEntry) {
+	if w.Proto() >= 3 {
+		w.WriteMapLen(len(names))
+	} else {
+		w.WriteArrayLen(len(names))
+	}
+	for i, name := range names {
+		if w.Proto() < 3 {
+			w.WriteArrayLen(2)
+		}
+		w.WriteBulkString(name)
+		writeStreamEntries(w, results[i])
+	}
 }
 
 func xrangeIsValidId(startSeq, endSeq, loopId string) bool {
@@ -287,15 +292,21 @@ func xreadIsValidId(startSeq, loopId string) bool {
 	return true
 }
 
-func XREAD(conn net.Conn, cmdOrg []interface{}) {
+func XREAD(c *Client, cmdOrg [][]byte) {
 	// [block 1000 streams mango 0-1]
 
-	blockStr := fmt.Sprintf("%s", cmdOrg[0])
-
-	if blockStr == "block" {
-		t, _ := cmdOrg[1].(int)
+	if strings.EqualFold(string(cmdOrg[0]), "block") {
+		if len(cmdOrg) < 5 {
+			c.W.WriteError("ERR wrong number of arguments for 'xread' command")
+			return
+		}
+		t, err := utils.ParseInt(cmdOrg[1])
+		if err != nil || t < 0 {
+			c.W.WriteError("ERR timeout is not an integer or out of range")
+			return
+		}
 
-		handleBlockStream(conn, cmdOrg[3:], t)
+		handleBlockStream(c, cmdOrg[3:], int(t))
 		return
 	}
 
@@ -304,73 +315,80 @@ func XREAD(conn net.Conn, cmdOrg []interface{}) {
 	namePointer := 0
 	seqPointer := len(cmd) / 2
 
-	var parentRes []ParentStreamEntry
+	var parentRes [][]store.StreamEntry
 
 	for namePointer < len(cmd)/2 {
-		streamKey := fmt.Sprintf("%s", cmd[namePointer])
-		entries := redisStreams[streamKey]
+		streamKey := string(cmd[namePointer])
+		stream, err := c.DB().Stream(streamKey)
+		if err != nil {
+			c.W.WriteError(err.Error())
+			return
+		}
 
-		var childRes []StreamEntry
-		for _, e := range entries {
-			if xreadIsValidId(fmt.Sprintf("%s", cmd[seqPointer]), e.ID) {
-				childRes = append(childRes, e)
+		var childRes []store.StreamEntry
+		if stream != nil {
+			for _, e := range stream.Entries {
+				if xreadIsValidId(string(cmd[seqPointer]), e.ID) {
+					childRes = append(childRes, e)
+				}
 			}
 		}
-		parentRes = append(parentRes, ParentStreamEntry{res: childRes})
+		parentRes = append(parentRes, childRes)
 
 		namePointer++
 		seqPointer++
 	}
 
-	var s strings.Builder
-
-	s.WriteString(fmt.Sprintf("*%d\r\n", len(parentRes)))
-
-	for i, e := range parentRes {
-		streamName := fmt.Sprintf("%s", cmd[i])
-
-		s.WriteString("*2\r\n")
-
-		s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(streamName), streamName))
+	names := make([]string, len(parentRes))
+	for i := range parentRes {
+		names[i] = string(cmd[i])
+	}
+	writeStreamsReply(c.W, names, parentRes)
+}
 
-		s.WriteString(fmt.Sprintf("*%d\r\n", len(e.res)))
+func handleBlockStream(c *Client, cmd [][]byte, blockMs int) {
+	streamKey := string(cmd[0])
+	seq := string(cmd[1])
 
-		for _, c := range e.res {
-			// entry = [id, fields]
-			s.WriteString("*2\r\n")
-			s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(c.ID), c.ID))
+	stream, err := c.DB().Stream(streamKey)
+	if err != nil {
+		c.W.WriteError(err.Error())
+		return
+	}
 
-			// fields
-			s.WriteString(fmt.Sprintf("*%d\r\n", len(c.Fields)*2))
-			for k, v := range c.Fields {
-				s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
-				s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
+	// Inside a transaction there is nobody to wait for, as EXEC holds the
+	// keyspace lock until it is done: reply with the entries after seq
+	// right away, or nil when there are none.
+	if c.inExec {
+		var entries []store.StreamEntry
+		if stream != nil && seq != "$" {
+			for _, e := range stream.Entries {
+				if isValidID(seq, e.ID) {
+					entries = append(entries, e)
+				}
 			}
 		}
+		if len(entries) == 0 {
+			c.W.WriteNullArray()
+			return
+		}
+		writeStreamsReply(c.W, []string{streamKey}, [][]store.StreamEntry{entries})
+		return
 	}
 
-	conn.Write([]byte(s.String()))
-}
-
-func handleBlockStream(conn net.Conn, cmd []interface{}, blockMs int) {
-	streamKey := fmt.Sprintf("%s", cmd[0])
-	seq := fmt.Sprintf("%s", cmd[1])
+	ch := make(chan store.StreamEntry, 1)
 
-	ch := make(chan StreamEntry, 1)
-
-	listWaitersStream.mu.Lock()
-	listWaitersStream.waiters[streamKey] = append(listWaitersStream.waiters[streamKey], Waiter{
+	wk := waitKey{c.DBIndex(), streamKey}
+	listWaitersStream.waiters[wk] = append(listWaitersStream.waiters[wk], Waiter{
 		seq: seq,
 		ch:  ch,
 	})
-	listWaitersStream.mu.Unlock()
 
-	// make sure stream exists
-	if _, ok := redisStreams[streamKey]; !ok {
-		redisStreams[streamKey] = []StreamEntry{}
-	}
+	// Let other clients run while we wait for an XADD.
+	store.Unlock()
+	defer store.Lock()
 
-	var entry StreamEntry
+	var entry store.StreamEntry
 	var ok bool
 
 	if blockMs == 0 {
@@ -379,34 +397,20 @@ func handleBlockStream(conn net.Conn, cmd []interface{}, blockMs int) {
 		select {
 		case entry, ok = <-ch:
 		case <-time.After(time.Duration(blockMs) * time.Millisecond):
-			conn.Write([]byte("*-1\r\n"))
+			c.W.WriteNullArray()
 			return
 		}
 	}
 
 	if !ok {
-		conn.Write([]byte("*-1\r\n"))
+		c.W.WriteNullArray()
 		return
 	}
 
 	if !xreadIsValidId(seq, entry.ID) {
-		conn.Write([]byte("*-1\r\n"))
+		c.W.WriteNullArray()
 		return
 	}
 
-	var s strings.Builder
-	s.WriteString("*1\r\n")
-	s.WriteString("*2\r\n")
-	s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(streamKey), streamKey))
-	s.WriteString("*1\r\n")
-	s.WriteString("*2\r\n")
-	s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(entry.ID), entry.ID))
-
-	s.WriteString(fmt.Sprintf("*%d\r\n", len(entry.Fields)*2))
-	for k, v := range entry.Fields {
-		s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(k), k))
-		s.WriteString(fmt.Sprintf("$%d\r\n%s\r\n", len(v), v))
-	}
-
-	conn.Write([]byte(s.String()))
+	writeStreamsReply(c.W, []string{streamKey}, [][]store.StreamEntry{{entry}})
 }
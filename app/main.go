@@ -1,10 +1,9 @@
-package cmds
+package main
 
 import (
-	"time"
-
-	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
@@ -13,28 +12,37 @@ import (
 	"sync"
 
 	"github.com/codecrafters-io/redis-starter-go/app/handlers"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
 
 	cmds "github.com/codecrafters-io/redis-starter-go/app/cmd"
 	"github.com/codecrafters-io/redis-starter-go/app/utils"
 )
 
-var redisKeyValueStore = make(map[string]interface{})
-var redisKeyExpiryTime = make(map[string]time.Time)
-var redisKeyTypeStore = make(map[string]string)
-
-var mu sync.RWMutex
+var replicasMu sync.RWMutex
 var replicas = make(map[net.Conn]bool)
 
+// replicasDB is the database the replication stream last selected, or -1
+// when the next command must be preceded by a SELECT.
+var replicasDB = -1
+
 func main() {
 	// Default port
 	PORT := "6379"
 
-	// Parse --port
+	// Parse --port and --databases
 	for i := 1; i < len(os.Args); i++ {
 		if os.Args[i] == "--port" && i+1 < len(os.Args) {
 			PORT = os.Args[i+1]
 			i++
 		}
+		if os.Args[i] == "--databases" && i+1 < len(os.Args) {
+			n, err := strconv.Atoi(os.Args[i+1])
+			if err != nil || n < 1 {
+				log.Fatalf("Invalid number of databases: %s", os.Args[i+1])
+			}
+			store.SetDatabases(n)
+			i++
+		}
 	}
 
 	// Listen
@@ -53,7 +61,12 @@ func main() {
 	}
 
 	if masterHost != "" && masterPort != "" {
+		store.SetReplica()
 		go connectToMaster(masterHost, masterPort, PORT)
+	} else {
+		// Replicas leave expiry to their master, which sends them a DEL.
+		store.Propagate = propagateToReplicas
+		go store.RunActiveExpire()
 	}
 
 	// Accept loop
@@ -69,91 +82,177 @@ func main() {
 
 func handleConnection(conn net.Conn) {
 	defer conn.Close()
-	buffer := make([]byte, 4096)
+	reader := utils.NewReader(conn)
+	client := handlers.NewConnClient(conn, reader)
 
 	var inTx bool
-	var txQueue [][]interface{}
+	var txQueue [][][]byte
 
 	for {
-		n, err := conn.Read(buffer)
+		cmdParser, err := reader.ReadCommand()
 		if err != nil {
+			var perr *utils.ProtocolError
+			if errors.As(err, &perr) {
+				client.W.WriteError(perr.Error())
+				client.W.Flush()
+			}
 			return
 		}
-		raw := string(buffer[:n])
-		cmdParser := utils.ParseRESP(raw)
-		if len(cmdParser) == 0 {
-			continue
-		}
 
-		cmd := strings.ToUpper(fmt.Sprintf("%v", cmdParser[0]))
+		cmd := strings.ToUpper(string(cmdParser[0]))
 
 		switch cmd {
 		case "PSYNC":
 			// New replica
-			conn.Write([]byte("+FULLRESYNC 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0\r\n"))
-			conn.Write([]byte("$-1\r\n")) // empty RDB
-			mu.Lock()
+			handlers.PSYNC(client)
+			client.W.Flush()
+			replicasMu.Lock()
 			replicas[conn] = true
-			mu.Unlock()
+			// The new replica starts out in database 0.
+			replicasDB = -1
+			replicasMu.Unlock()
 
 		case "REPLCONF":
-			// Accept ACKs or other replconf
-			conn.Write([]byte("+OK\r\n"))
+			// ACKs from replicas are not answered
+			if len(cmdParser) > 1 && strings.ToUpper(string(cmdParser[1])) == "ACK" {
+				continue
+			}
+			client.W.WriteSimpleString("OK")
 
 		case "MULTI":
 			inTx = true
-			txQueue = [][]any{}
-			conn.Write([]byte("+OK\r\n"))
+			txQueue = [][][]byte{}
+			client.W.WriteSimpleString("OK")
 
 		case "DISCARD":
 			if inTx {
 				txQueue = nil
-				conn.Write([]byte("+OK\r\n"))
+				client.W.WriteSimpleString("OK")
 				inTx = false
 			} else {
-				conn.Write([]byte("-ERR DISCARD without MULTI\r\n"))
+				client.W.WriteError("ERR DISCARD without MULTI")
 			}
 
 		case "EXEC":
 			if !inTx {
-				conn.Write([]byte("-ERR EXEC without MULTI\r\n"))
-				continue
+				client.W.WriteError("ERR EXEC without MULTI")
+				break
 			}
 			inTx = false
-			conn.Write([]byte("*" + strconv.Itoa(len(txQueue)) + "\r\n"))
-			for _, q := range txQueue {
-				handleCommand(conn, q)
-			}
+			execTransaction(client, txQueue)
 			txQueue = nil
 
 		default:
 			if inTx {
 				txQueue = append(txQueue, cmdParser)
-				conn.Write([]byte("+QUEUED\r\n"))
+				client.W.WriteSimpleString("QUEUED")
 			} else {
-				handleCommand(conn, cmdParser)
+				handleCommand(client, cmdParser)
 			}
 		}
+
+		if err := client.W.Flush(); err != nil {
+			return
+		}
 	}
 }
 
-func handleCommand(conn net.Conn, cmdParser []interface{}) {
-	cmd := strings.ToUpper(fmt.Sprintf("%v", cmdParser[0]))
-	writeCommands := map[string]bool{
-		"SET":  true,
-		"DEL":  true,
-		"INCR": true,
-		"DECR": true,
+// writeCommands are propagated to replicas as they were sent, unless the
+// handler rewrote them.
+var writeCommands = map[string]bool{
+	"SET":         true,
+	"DEL":         true,
+	"UNLINK":      true,
+	"RENAME":      true,
+	"RENAMENX":    true,
+	"COPY":        true,
+	"INCR":        true,
+	"DECR":        true,
+	"INCRBY":      true,
+	"DECRBY":      true,
+	"INCRBYFLOAT": true,
+	"PERSIST":     true,
+	"MOVE":        true,
+	"SWAPDB":      true,
+	"FLUSHDB":     true,
+	"FLUSHALL":    true,
+	"RPUSH":       true,
+	"LPUSH":       true,
+	"LPOP":        true,
+	"RPOP":        true,
+	"LSET":        true,
+	"LINSERT":     true,
+	"LREM":        true,
+	"LTRIM":       true,
+	"LPUSHX":      true,
+	"RPUSHX":      true,
+	"PFADD":       true,
+	"PFMERGE":     true,
+	"SETBIT":      true,
+	"BITOP":       true,
+	"BITFIELD":    true,
+	"MSET":        true,
+	"MSETNX":      true,
+	"APPEND":      true,
+	"SETRANGE":    true,
+	"GETSET":      true,
+	"GETDEL":      true,
+	"SETNX":       true,
+	"SETEX":       true,
+	"PSETEX":      true,
+	"XADD":        true,
+}
+
+// handleCommand runs a command with the keyspace locked and propagates it.
+func handleCommand(c *handlers.Client, cmdParser [][]byte) {
+	// Apply locally and propagate under the same lock, so that replicas get
+	// writes in the order they ran here.
+	store.Lock()
+	defer store.Unlock()
+	for _, p := range runCommand(c, cmdParser) {
+		propagateToReplicas(p.DB, p.Argv)
 	}
-	if writeCommands[cmd] {
-		// Apply locally
-		cmds.RunCmds(conn, cmdParser)
-		// Propagate
-		strCmd := utils.InterfaceSliceToStringSlice(cmdParser)
-		propagateToReplicas(strCmd)
-	} else {
-		cmds.RunCmds(conn, cmdParser)
+}
+
+// execTransaction runs the commands queued by MULTI. The keyspace stays
+// locked until the last one is done, so no other client runs in between,
+// and replicas get the writes wrapped in MULTI and EXEC so they apply them
+// as one unit too.
+func execTransaction(c *handlers.Client, queue [][][]byte) {
+	store.Lock()
+	defer store.Unlock()
+	c.SetInExec(true)
+	defer c.SetInExec(false)
+
+	c.W.WriteArrayLen(len(queue))
+	var props []handlers.Propagation
+	for _, argv := range queue {
+		props = append(props, runCommand(c, argv)...)
+	}
+	if len(props) == 0 {
+		return
+	}
+	propagateToReplicas(props[0].DB, [][]byte{[]byte("MULTI")})
+	for _, p := range props {
+		propagateToReplicas(p.DB, p.Argv)
+	}
+	propagateToReplicas(props[len(props)-1].DB, [][]byte{[]byte("EXEC")})
+}
+
+// runCommand executes a command and returns what it sends to replicas. The
+// keyspace lock must be held. A write that replies with an error changed
+// nothing, so it is not sent.
+func runCommand(c *handlers.Client, cmdParser [][]byte) []handlers.Propagation {
+	errs := c.W.Errors()
+	cmds.RunCmds(c, cmdParser)
+
+	var props []handlers.Propagation
+	if argv := c.TakeRewrite(); argv != nil {
+		props = append(props, handlers.Propagation{DB: c.DBIndex(), Argv: argv})
+	} else if writeCommands[strings.ToUpper(string(cmdParser[0]))] && c.W.Errors() == errs {
+		props = append(props, handlers.Propagation{DB: c.DBIndex(), Argv: cmdParser})
 	}
+	return append(props, c.TakeAlsoPropagate()...)
 }
 
 func connectToMaster(masterHost, masterPort, replicaPort string) {
@@ -161,127 +260,114 @@ func connectToMaster(masterHost, masterPort, replicaPort string) {
 	if err != nil {
 		log.Fatalf("Failed to connect to master: %v", err)
 	}
+	reader := utils.NewReader(conn)
 
 	// Handshake
 	conn.Write([]byte("*1\r\n$4\r\nPING\r\n"))
-	buf := make([]byte, 1024)
-	n, _ := conn.Read(buf)
-	if string(buf[:n]) != "+PONG\r\n" {
-		log.Fatalf("Expected +PONG, got: %q", string(buf[:n]))
+	if line, _ := reader.ReadLine(); line != "+PONG" {
+		log.Fatalf("Expected +PONG, got: %q", line)
 	}
 
-	sendReplConf(conn, replicaPort)
-	sendPSYNC(conn)
-	readFromMaster(conn)
+	sendReplConf(conn, reader, replicaPort)
+	sendPSYNC(conn, reader)
+	readFromMaster(conn, reader)
 }
 
-func sendReplConf(conn net.Conn, replicaPort string) {
+func sendReplConf(conn net.Conn, reader *utils.Reader, replicaPort string) {
 	// REPLCONF listening-port
 	replConfListening := fmt.Sprintf(
 		"*3\r\n$8\r\nREPLCONF\r\n$14\r\nlistening-port\r\n$%d\r\n%s\r\n",
 		len(replicaPort), replicaPort,
 	)
 	conn.Write([]byte(replConfListening))
-	buf := make([]byte, 1024)
-	conn.Read(buf)
+	reader.ReadLine()
 
 	// REPLCONF capa psync2
 	replCapa := "*3\r\n$8\r\nREPLCONF\r\n$4\r\ncapa\r\n$6\r\npsync2\r\n"
 	conn.Write([]byte(replCapa))
-	conn.Read(buf)
+	reader.ReadLine()
 }
 
-func sendPSYNC(conn net.Conn) {
+func sendPSYNC(conn net.Conn, reader *utils.Reader) {
 	psync := "*3\r\n$5\r\nPSYNC\r\n$1\r\n?\r\n$2\r\n-1\r\n"
 	conn.Write([]byte(psync))
-	buf := make([]byte, 1024)
-	conn.Read(buf) // +FULLRESYNC
-	conn.Read(buf) // RDB
+	reader.ReadLine() // +FULLRESYNC
+	if _, err := reader.ReadRDB(); err != nil {
+		log.Fatalf("Failed to read RDB from master: %v", err)
+	}
 }
 
-func propagateToReplicas(cmd []string) {
-	resp := utils.EncodeAsRESPArray(cmd)
-	mu.RLock()
-	defer mu.RUnlock()
+// propagateToReplicas sends a write executed in database db to every
+// replica, selecting db first if the stream is currently in another one.
+func propagateToReplicas(db int, cmd [][]byte) {
+	replicasMu.Lock()
+	defer replicasMu.Unlock()
+	if len(replicas) == 0 {
+		return
+	}
+	var resp []byte
+	if db != replicasDB {
+		resp = utils.EncodeAsRESPArray([][]byte{[]byte("SELECT"), []byte(strconv.Itoa(db))})
+		replicasDB = db
+	}
+	resp = append(resp, utils.EncodeAsRESPArray(cmd)...)
 	for r := range replicas {
-		_, err := r.Write([]byte(resp))
+		_, err := r.Write(resp)
 		if err != nil {
 			log.Println("Failed to propagate:", err)
 		}
 	}
 }
 
-func readFromMaster(conn net.Conn) {
-	buffer := make([]byte, 4096)
-	var accumulated []byte
-	rdbDone := false
+func readFromMaster(conn net.Conn, reader *utils.Reader) {
+	// The replication offset counts every byte of the command stream
+	// received after the RDB payload.
+	base := reader.Consumed()
+	client := handlers.NewClient(io.Discard)
+	// A transaction is collected up to its EXEC and applied in one go.
+	var inTx bool
+	var txQueue [][][]byte
 
 	for {
-		n, err := conn.Read(buffer)
+		offset := reader.Consumed() - base
+		cmd, err := reader.ReadCommand()
 		if err != nil {
 			log.Println("Lost connection to master:", err)
 			return
 		}
-		accumulated = append(accumulated, buffer[:n]...)
 
-		if !rdbDone {
-			if bytes.Contains(accumulated, []byte("REDIS")) || bytes.Contains(accumulated, []byte("$-1")) {
-				accumulated = nil
-				rdbDone = true
-			}
+		if strings.EqualFold(string(cmd[0]), "REPLCONF") && len(cmd) > 1 &&
+			strings.EqualFold(string(cmd[1]), "GETACK") {
+			ack := strconv.FormatInt(offset, 10)
+			fmt.Fprintf(conn, "*3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$%d\r\n%s\r\n", len(ack), ack)
 			continue
 		}
 
-		cmd := utils.ParseRESP(string(accumulated))
-		if len(cmd) == 0 {
-			continue
+		switch name := strings.ToUpper(string(cmd[0])); {
+		case name == "MULTI":
+			inTx, txQueue = true, nil
+		case name == "EXEC" && inTx:
+			applyFromMaster(client, txQueue...)
+			inTx, txQueue = false, nil
+		case inTx:
+			txQueue = append(txQueue, cmd)
+		default:
+			applyFromMaster(client, cmd)
 		}
-		// Apply locally
-		cmds.RunCmds(nil, cmd)
-
-		// Send ACK
-		ack := "*3\r\n$8\r\nREPLCONF\r\n$3\r\nACK\r\n$1\r\n0\r\n"
-		conn.Write([]byte(ack))
-		accumulated = nil
 	}
 }
 
-func RunCmds(conn net.Conn, cmdParser []interface{}) {
-	if len(cmdParser) == 0 {
-		return
-	}
-
-	switch strings.ToUpper(fmt.Sprintf("%v", cmdParser[0])) {
-	case "PING":
-		if conn != nil {
-			conn.Write([]byte("+PONG\r\n"))
-		}
-	case "ECHO":
-		if conn != nil {
-			if len(cmdParser) > 1 {
-				conn.Write([]byte("+" + fmt.Sprintf("%v", cmdParser[1]) + "\r\n"))
-			} else {
-				conn.Write([]byte("+\r\n"))
-			}
-		}
-	case "SET":
-		key := fmt.Sprintf("%v", cmdParser[1])
-		redisKeyTypeStore[key] = "string"
-		handlers.SET(cmdParser[1:], conn)
-	case "GET":
-		handlers.GET(cmdParser[1:], conn)
-	case "TYPE":
-		key := fmt.Sprintf("%v", cmdParser[1])
-		if val, ok := redisKeyTypeStore[key]; ok {
-			fmt.Fprintf(conn, "+%s\r\n", val)
-		} else {
-			fmt.Fprintf(conn, "+none\r\n")
-		}
-	case "INFO":
-		handlers.INFO(conn, cmdParser)
-	default:
-		if conn != nil {
-			conn.Write([]byte("-ERR unknown command\r\n"))
-		}
+// applyFromMaster runs commands received from the master with the keyspace
+// locked throughout. Replicas don't propagate any further.
+func applyFromMaster(client *handlers.Client, queue ...[][]byte) {
+	store.Lock()
+	store.SetFromMaster(true)
+	for _, cmd := range queue {
+		cmds.RunCmds(client, cmd)
 	}
+	store.SetFromMaster(false)
+	store.Unlock()
+	client.TakeRewrite()
+	client.TakeAlsoPropagate()
+	client.W.Flush()
 }
@@ -0,0 +1,600 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/handlers"
+	"github.com/codecrafters-io/redis-starter-go/app/store"
+	"github.com/codecrafters-io/redis-starter-go/app/utils"
+)
+
+// startServer serves connections on a random local port until the test
+// ends and returns its address.
+func startServer(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+type testConn struct {
+	t    *testing.T
+	conn net.Conn
+	rd   *utils.Reader
+}
+
+func dial(t *testing.T, addr string) *testConn {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testConn{t: t, conn: conn, rd: utils.NewReader(conn)}
+}
+
+func (c *testConn) send(args ...string) {
+	c.t.Helper()
+	argv := make([][]byte, len(args))
+	for i, a := range args {
+		argv[i] = []byte(a)
+	}
+	if _, err := c.conn.Write(utils.EncodeAsRESPArray(argv)); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// reply reads one reply and renders it as a string: status and error lines
+// as sent, integers as ":n", bulk strings as their value, nil as "(nil)"
+// and arrays as their elements in brackets.
+func (c *testConn) reply() string {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	defer c.conn.SetReadDeadline(time.Time{})
+	s, err := readReply(c.rd)
+	if err != nil {
+		c.t.Fatalf("reading reply: %v", err)
+	}
+	return s
+}
+
+func (c *testConn) do(args ...string) string {
+	c.t.Helper()
+	c.send(args...)
+	return c.reply()
+}
+
+func readReply(rd *utils.Reader) (string, error) {
+	line, err := rd.ReadLine()
+	if err != nil {
+		return "", err
+	}
+	switch line[0] {
+	case '+', '-', ':':
+		return line, nil
+	case '$':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)", nil
+		}
+		s, err := rd.ReadLine()
+		return s, err
+	case '*':
+		n, _ := strconv.Atoi(line[1:])
+		if n < 0 {
+			return "(nil)", nil
+		}
+		elems := make([]string, n)
+		for i := range elems {
+			if elems[i], err = readReply(rd); err != nil {
+				return "", err
+			}
+		}
+		return "[" + strings.Join(elems, " ") + "]", nil
+	}
+	return "", fmt.Errorf("unexpected reply %q", line)
+}
+
+// replicaStream connects as a replica and returns a function reading the
+// next command the master propagates, joined with spaces.
+func replicaStream(t *testing.T, addr string) func() string {
+	t.Helper()
+	c := dial(t, addr)
+	c.send("PSYNC", "?", "-1")
+	if line, err := c.rd.ReadLine(); err != nil || !strings.HasPrefix(line, "+FULLRESYNC") {
+		t.Fatalf("PSYNC: %q, %v", line, err)
+	}
+	if _, err := c.rd.ReadRDB(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		replicasMu.Lock()
+		for r := range replicas {
+			r.Close()
+			delete(replicas, r)
+		}
+		replicasMu.Unlock()
+	})
+	// The replica is registered after the RDB is sent; a round trip on
+	// the same connection makes sure it is.
+	c.send("REPLCONF", "listening-port", "0")
+	c.rd.ReadLine()
+	return func() string {
+		t.Helper()
+		c.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		defer c.conn.SetReadDeadline(time.Time{})
+		cmd, err := c.rd.ReadCommand()
+		if err != nil {
+			t.Fatalf("reading replication stream: %v", err)
+		}
+		parts := make([]string, len(cmd))
+		for i, a := range cmd {
+			parts[i] = string(a)
+		}
+		return strings.Join(parts, " ")
+	}
+}
+
+// TestExecAtomic checks that no other client runs between the commands of
+// a transaction.
+func TestExecAtomic(t *testing.T) {
+	addr := startServer(t)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		other := dial(t, addr)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			other.do("SET", "atomic", "other")
+		}
+	}()
+	defer func() {
+		close(stop)
+		wg.Wait()
+	}()
+
+	c := dial(t, addr)
+	for round := 0; round < 5; round++ {
+		c.do("MULTI")
+		c.do("SET", "atomic", "mine")
+		// Enough commands for the transaction to take several
+		// milliseconds, so the other client gets a chance to cut in.
+		const filler = 20000
+		for i := 0; i < filler; i++ {
+			c.send("INCR", "atomic:counter")
+		}
+		for i := 0; i < filler; i++ {
+			if got := c.reply(); got != "+QUEUED" {
+				t.Fatalf("queueing: %s", got)
+			}
+		}
+		c.do("GET", "atomic")
+		got := c.do("EXEC")
+		if !strings.HasSuffix(got, " mine]") {
+			t.Fatalf("round %d: another client ran inside the transaction: ...%s",
+				round, got[max(0, len(got)-20):])
+		}
+	}
+}
+
+func TestExecPropagatesMultiExec(t *testing.T) {
+	addr := startServer(t)
+	next := replicaStream(t, addr)
+	c := dial(t, addr)
+
+	c.do("MULTI")
+	c.do("SET", "tx:a", "1")
+	c.do("GET", "tx:a")
+	c.do("INCR", "tx:a")
+	if got := c.do("EXEC"); got != "[+OK 1 :2]" {
+		t.Fatalf("EXEC = %s", got)
+	}
+	// A transaction without writes is not propagated at all.
+	c.do("MULTI")
+	c.do("GET", "tx:a")
+	c.do("EXEC")
+	c.do("SET", "tx:b", "x")
+
+	want := []string{"SELECT 0", "MULTI", "SET tx:a 1", "INCR tx:a", "EXEC", "SET tx:b x"}
+	for _, w := range want {
+		if got := next(); got != w {
+			t.Fatalf("replication stream: got %q, want %q", got, w)
+		}
+	}
+}
+
+// TestReplicaAppliesTransaction checks that a replica applies a propagated
+// transaction once its EXEC arrives, and answers GETACK in the middle of it.
+func TestReplicaAppliesTransaction(t *testing.T) {
+	master, replica := net.Pipe()
+	t.Cleanup(func() { master.Close() })
+	go readFromMaster(replica, utils.NewReader(replica))
+
+	send := func(args ...string) {
+		argv := make([][]byte, len(args))
+		for i, a := range args {
+			argv[i] = []byte(a)
+		}
+		if _, err := master.Write(utils.EncodeAsRESPArray(argv)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	get := func(key string) string {
+		store.Lock()
+		defer store.Unlock()
+		v, _ := store.Select(0).String(key)
+		return string(v)
+	}
+
+	store.Lock()
+	store.Select(0).Delete("replica:tx")
+	store.Unlock()
+
+	send("MULTI")
+	send("SET", "replica:tx", "1")
+	send("REPLCONF", "GETACK", "*")
+	ack, err := utils.NewReader(master).ReadCommand()
+	if err != nil || len(ack) != 3 || string(ack[1]) != "ACK" {
+		t.Fatalf("GETACK inside a transaction: %q, %v", ack, err)
+	}
+	if got := get("replica:tx"); got != "" {
+		t.Fatalf("applied before EXEC: %q", got)
+	}
+	send("INCR", "replica:tx")
+	send("EXEC")
+	// Commands are applied in order, so once this one is done the
+	// transaction is too.
+	send("REPLCONF", "GETACK", "*")
+	if _, err := utils.NewReader(master).ReadCommand(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get("replica:tx"); got != "2" {
+		t.Fatalf("after EXEC: %q, want 2", got)
+	}
+}
+
+func TestXADDPropagatesID(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "stream:repl")
+	next := replicaStream(t, addr)
+
+	c.do("XADD", "stream:repl", "5-*", "g", "w")
+	id := c.do("XADD", "stream:repl", "*", "f", "v")
+	for _, bad := range []string{"1-1", "5-*"} {
+		if got := c.do("XADD", "stream:repl", bad, "f", "v"); !strings.HasPrefix(got, "-ERR") {
+			t.Fatalf("XADD with an ID below the top item: %s", got)
+		}
+	}
+	c.do("SET", "stream:after", "1")
+	// Failed writes are not sent at all.
+	want := []string{"SELECT 0", "XADD stream:repl 5-0 g w", "XADD stream:repl " + id + " f v", "SET stream:after 1"}
+	for _, w := range want {
+		if got := next(); got != w {
+			t.Fatalf("replication stream: got %q, want %q", got, w)
+		}
+	}
+}
+
+// TestBlockingInsideExec checks that blocking reads inside a transaction
+// reply straight away instead of blocking with the keyspace locked.
+func TestBlockingInsideExec(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	c.do("XADD", "exec:stream", "1-1", "f", "v")
+	c.do("MULTI")
+	c.do("BLPOP", "exec:list", "0")
+	c.do("BLMOVE", "exec:list", "exec:other", "LEFT", "RIGHT", "0")
+	c.do("XREAD", "BLOCK", "0", "STREAMS", "exec:stream", "$")
+	c.do("XREAD", "BLOCK", "0", "STREAMS", "exec:stream", "0-0")
+	c.do("XREAD", "BLOCK", "0", "STREAMS", "exec:stream", "1-1")
+	want := "[(nil) (nil) (nil) [[exec:stream [[1-1 [f v]]]]] (nil)]"
+	if got := c.do("EXEC"); got != want {
+		t.Fatalf("EXEC = %s, want %s", got, want)
+	}
+}
+
+func TestLCSLimit(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	long := strings.Repeat("ab", 10000)
+	c.do("SET", "lcs:a", long)
+	c.do("SET", "lcs:b", long)
+	for _, opt := range [][]string{nil, {"LEN"}, {"IDX"}} {
+		got := c.do(append([]string{"LCS", "lcs:a", "lcs:b"}, opt...)...)
+		if !strings.HasPrefix(got, "-ERR Insufficient memory") {
+			t.Errorf("LCS %v = %.40s, want the memory error", opt, got)
+		}
+	}
+	c.do("SET", "lcs:b", "xbx")
+	if got := c.do("LCS", "lcs:a", "lcs:b", "LEN"); got != ":1" {
+		t.Errorf("LCS LEN = %s, want :1", got)
+	}
+}
+
+func TestHELLOErrors(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+
+	if got := c.do("HELLO", "4"); got != "-NOPROTO unsupported protocol version" {
+		t.Errorf("HELLO 4 = %s", got)
+	}
+	for _, name := range []string{"a b", "a\nb", "a\tb", "caf\xc3\xa9", "a\x00"} {
+		if got := c.do("HELLO", "2", "SETNAME", name); !strings.HasPrefix(got, "-ERR Client names") {
+			t.Errorf("HELLO SETNAME %q = %s", name, got)
+		}
+	}
+	if got := c.do("HELLO", "2", "SETNAME", "conn-1!~"); !strings.HasPrefix(got, "[server") {
+		t.Errorf("HELLO SETNAME with a valid name = %.40s", got)
+	}
+}
+
+// settle gives the server time to act on commands that send no reply, such
+// as a BLPOP that blocks or a connection that closes.
+func settle() {
+	time.Sleep(100 * time.Millisecond)
+}
+
+func TestBlockingPopOrderAndDisconnect(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "bl:a", "bl:b", "bl:dst")
+
+	// Clients are served in the order they blocked.
+	first, second := dial(t, addr), dial(t, addr)
+	first.send("BLPOP", "bl:a", "bl:b", "0")
+	settle()
+	second.send("BLPOP", "bl:b", "0")
+	settle()
+	c.do("RPUSH", "bl:b", "1", "2")
+	if got := first.reply(); got != "[bl:b 1]" {
+		t.Errorf("first client got %s", got)
+	}
+	if got := second.reply(); got != "[bl:b 2]" {
+		t.Errorf("second client got %s", got)
+	}
+
+	// A client that disconnects gives up its place, and the element goes
+	// to the next one in line.
+	gone, next := dial(t, addr), dial(t, addr)
+	gone.send("BLPOP", "bl:a", "0")
+	settle()
+	next.send("BRPOP", "bl:a", "0")
+	settle()
+	gone.conn.Close()
+	settle()
+	c.do("RPUSH", "bl:a", "x")
+	if got := next.reply(); got != "[bl:a x]" {
+		t.Errorf("next client got %s", got)
+	}
+
+	// With nobody left the element stays in the list.
+	gone = dial(t, addr)
+	gone.send("BLMOVE", "bl:a", "bl:dst", "LEFT", "RIGHT", "0")
+	settle()
+	gone.conn.Close()
+	settle()
+	c.do("RPUSH", "bl:a", "y")
+	if got := c.do("LRANGE", "bl:a", "0", "-1"); got != "[y]" {
+		t.Errorf("list after the only client left: %s", got)
+	}
+	if got := c.do("EXISTS", "bl:dst"); got != ":0" {
+		t.Errorf("destination of the BLMOVE that left: EXISTS = %s", got)
+	}
+
+	// A client served by a push that disconnects before it could reply
+	// puts back what was popped for it. Holding the lock keeps it from
+	// replying until the disconnect is seen.
+	c.do("DEL", "bl:a")
+	gone = dial(t, addr)
+	gone.send("BLPOP", "bl:a", "0")
+	settle()
+	store.Lock()
+	runCommand(handlers.NewClient(io.Discard), [][]byte{[]byte("RPUSH"), []byte("bl:a"), []byte("z")})
+	gone.conn.Close()
+	settle()
+	store.Unlock()
+	if got := c.do("LRANGE", "bl:a", "0", "-1"); got != "[z]" {
+		t.Errorf("list after the served client left: %s", got)
+	}
+}
+
+// expectStream reads a command from next for every entry of want and
+// compares them. An "@+N" field in want stands for a unix time in
+// milliseconds about N milliseconds from now.
+func expectStream(t *testing.T, next func() string, want ...string) {
+	t.Helper()
+	for _, w := range want {
+		got := next()
+		gotFields, wantFields := strings.Fields(got), strings.Fields(w)
+		match := len(gotFields) == len(wantFields)
+		for i := 0; match && i < len(wantFields); i++ {
+			ttl, isTime := strings.CutPrefix(wantFields[i], "@+")
+			if !isTime {
+				match = gotFields[i] == wantFields[i]
+				continue
+			}
+			ms, _ := strconv.ParseInt(ttl, 10, 64)
+			at, err := strconv.ParseInt(gotFields[i], 10, 64)
+			if diff := at - (time.Now().UnixMilli() + ms); err != nil || diff < -5000 || diff > 5000 {
+				match = false
+			}
+		}
+		if !match {
+			t.Fatalf("replication stream: got %q, want %q", got, w)
+		}
+	}
+}
+
+func TestExpireReplication(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "exp:a", "exp:b", "exp:missing")
+	next := replicaStream(t, addr)
+
+	c.do("SET", "exp:a", "1")
+	c.do("SET", "exp:b", "1")
+	for _, cmd := range [][]string{
+		{"EXPIRE", "exp:a", "100"},
+		{"PEXPIRE", "exp:a", "100000"},
+		{"EXPIREAT", "exp:a", strconv.FormatInt(time.Now().Unix()+100, 10)},
+		{"PEXPIREAT", "exp:a", strconv.FormatInt(time.Now().UnixMilli()+100000, 10)},
+	} {
+		if got := c.do(cmd...); got != ":1" {
+			t.Fatalf("%v = %s", cmd, got)
+		}
+	}
+	// Replies of 0 change nothing and are not sent.
+	c.do("EXPIRE", "exp:missing", "100")
+	c.do("EXPIRE", "exp:a", "100", "NX")
+	// A time in the past deletes the key.
+	c.do("EXPIRE", "exp:b", "-1")
+	c.do("SET", "exp:end", "1")
+
+	expectStream(t, next,
+		"SELECT 0",
+		"SET exp:a 1",
+		"SET exp:b 1",
+		"PEXPIREAT exp:a @+100000",
+		"PEXPIREAT exp:a @+100000",
+		"PEXPIREAT exp:a @+100000",
+		"PEXPIREAT exp:a @+100000",
+		"DEL exp:b",
+		"SET exp:end 1",
+	)
+	if got := c.do("EXISTS", "exp:b"); got != ":0" {
+		t.Errorf("EXISTS after EXPIRE -1 = %s", got)
+	}
+}
+
+func TestSETReplication(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "set:k")
+	next := replicaStream(t, addr)
+
+	exat := strconv.FormatInt(time.Now().Unix()+100, 10)
+	pxat := strconv.FormatInt(time.Now().UnixMilli()+100000, 10)
+	c.do("SET", "set:k", "1", "EX", "100")
+	c.do("SET", "set:k", "2", "PX", "100000", "GET")
+	c.do("SET", "set:k", "3", "EXAT", exat)
+	c.do("SET", "set:k", "4", "PXAT", pxat)
+	c.do("SET", "set:k", "5", "KEEPTTL")
+	c.do("SET", "set:k", "6", "XX")
+
+	expectStream(t, next,
+		"SELECT 0",
+		"SET set:k 1 PXAT @+100000",
+		"SET set:k 2 PXAT @+100000 GET",
+		"SET set:k 3 PXAT "+exat+"000",
+		"SET set:k 4 PXAT "+pxat,
+		"SET set:k 5 KEEPTTL",
+		"SET set:k 6 XX",
+	)
+}
+
+func TestStringTTLReplication(t *testing.T) {
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "str:k")
+	next := replicaStream(t, addr)
+
+	c.do("SETEX", "str:k", "100", "v")
+	c.do("PSETEX", "str:k", "100000", "v")
+	c.do("GETEX", "str:k", "EX", "100")
+	// GETEX without options, or PERSIST on a key without a TTL, changes
+	// nothing and is not sent.
+	c.do("GETEX", "str:k")
+	c.do("GETEX", "str:k", "PERSIST")
+	c.do("GETEX", "str:k", "PERSIST")
+	c.do("GETEX", "str:k", "EX", "-1")
+	c.do("GETEX", "str:missing", "EX", "100")
+	c.do("SET", "str:end", "1")
+
+	expectStream(t, next,
+		"SELECT 0",
+		"SET str:k v PXAT @+100000",
+		"SET str:k v PXAT @+100000",
+		"PEXPIREAT str:k @+100000",
+		"PERSIST str:k",
+		"SET str:end 1",
+	)
+	if got := c.do("TTL", "str:k"); got != ":-1" {
+		t.Errorf("TTL after GETEX PERSIST = %s", got)
+	}
+}
+
+func TestHashFieldTTL(t *testing.T) {
+	store.Propagate = propagateToReplicas
+	t.Cleanup(func() { store.Propagate = nil })
+	addr := startServer(t)
+	c := dial(t, addr)
+	c.do("DEL", "ht")
+	next := replicaStream(t, addr)
+
+	c.do("HSET", "ht", "a", "1", "b", "2", "c", "3")
+	if got := c.do("HPEXPIRE", "ht", "100", "FIELDS", "2", "a", "nope"); got != "[:1 :-2]" {
+		t.Fatalf("HPEXPIRE = %s", got)
+	}
+	c.do("HEXPIRE", "ht", "100", "FIELDS", "1", "b")
+	if got := c.do("HTTL", "ht", "FIELDS", "3", "b", "c", "nope"); got != "[:100 :-1 :-2]" {
+		t.Errorf("HTTL = %s", got)
+	}
+	if got := c.do("HPERSIST", "ht", "FIELDS", "2", "b", "c"); got != "[:1 :-1]" {
+		t.Errorf("HPERSIST = %s", got)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if got := c.do("HGET", "ht", "a"); got != "(nil)" {
+		t.Errorf("HGET of an expired field = %s", got)
+	}
+	if got := c.do("HGETALL", "ht"); got != "[b 2 c 3]" {
+		t.Errorf("HGETALL = %s", got)
+	}
+
+	// The key goes once its last field does.
+	c.do("HPEXPIRE", "ht", "50", "FIELDS", "2", "b", "c")
+	time.Sleep(100 * time.Millisecond)
+	if got := c.do("EXISTS", "ht"); got != ":0" {
+		t.Errorf("EXISTS with every field expired = %s", got)
+	}
+
+	expectStream(t, next,
+		"SELECT 0",
+		"HSET ht a 1 b 2 c 3",
+		"HPEXPIREAT ht @+100 FIELDS 1 a",
+		"HPEXPIREAT ht @+100000 FIELDS 1 b",
+		"HPERSIST ht FIELDS 1 b",
+		"HDEL ht a",
+		"HPEXPIREAT ht @+50 FIELDS 2 b c",
+		"DEL ht",
+	)
+}
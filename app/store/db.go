@@ -0,0 +1,354 @@
+package store
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var ErrWrongType = errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+
+// mu serialises every access to the keyspace. Commands run with it held, the
+// same way Redis runs them on its single main thread, so handlers never lock
+// anything themselves. Blocking commands release it only while they are
+// parked waiting for data.
+var mu sync.Mutex
+
+func Lock() {
+	mu.Lock()
+}
+
+func Unlock() {
+	mu.Unlock()
+}
+
+// DB maps every key to exactly one value. The Go type of the value decides
+// its Redis type: []byte is a string, *List a list, *Hash a hash and
+// *Stream a stream.
+// String values are never nil, so a nil []byte always means "no such key".
+// The keyspace owns its strings: commands store a copy of an argument, never
+// the argument itself, so that SETBIT and friends can modify a value in
+// place without touching a command that is still being replicated.
+// Keys with a TTL also have an entry in expires, holding the unix time in
+// milliseconds at which they disappear.
+type DB struct {
+	ID int
+
+	dict    *Dict[any]
+	expires *Dict[int64]
+
+	// expiresCursor is where the active expire cycle resumes scanning.
+	expiresCursor uint64
+	// avgTTL is a running estimate of the TTL of volatile keys in
+	// milliseconds, sampled by the active expire cycle.
+	avgTTL int64
+
+	// hashExpires holds the keys of hashes with field TTLs for the active
+	// expire cycle, which drops entries that no longer hold such a hash.
+	hashExpires       *Dict[struct{}]
+	hashExpiresCursor uint64
+}
+
+func NewDB(id int) *DB {
+	return &DB{
+		ID:          id,
+		dict:        NewDict[any](),
+		expires:     NewDict[int64](),
+		hashExpires: NewDict[struct{}](),
+	}
+}
+
+// DefaultDatabases is the number of databases unless --databases says
+// otherwise.
+const DefaultDatabases = 16
+
+// databases are the logical databases clients pick with SELECT.
+var databases = newDatabases(DefaultDatabases)
+
+func newDatabases(n int) []*DB {
+	dbs := make([]*DB, n)
+	for i := range dbs {
+		dbs[i] = NewDB(i)
+	}
+	return dbs
+}
+
+// SetDatabases sets the number of databases. It must be called before the
+// server starts serving clients.
+func SetDatabases(n int) {
+	databases = newDatabases(n)
+}
+
+func NumDatabases() int {
+	return len(databases)
+}
+
+// Select returns database i, or nil when i is out of range.
+func Select(i int) *DB {
+	if i < 0 || i >= len(databases) {
+		return nil
+	}
+	return databases[i]
+}
+
+// SwapDB exchanges the contents of two databases. Clients stay connected to
+// the same index, so they see the other database's data from then on.
+func SwapDB(a, b int) {
+	x, y := databases[a], databases[b]
+	x.dict, y.dict = y.dict, x.dict
+	x.expires, y.expires = y.expires, x.expires
+	x.expiresCursor, y.expiresCursor = y.expiresCursor, x.expiresCursor
+	x.avgTTL, y.avgTTL = y.avgTTL, x.avgTTL
+	x.hashExpires, y.hashExpires = y.hashExpires, x.hashExpires
+	x.hashExpiresCursor, y.hashExpiresCursor = y.hashExpiresCursor, x.hashExpiresCursor
+}
+
+// Flush removes every key. With async set the old contents are released by
+// the lazyfree goroutine.
+func (db *DB) Flush(async bool) {
+	old := db.dict
+	db.dict = NewDict[any]()
+	db.expires = NewDict[int64]()
+	db.expiresCursor = 0
+	db.avgTTL = 0
+	db.hashExpires = NewDict[struct{}]()
+	db.hashExpiresCursor = 0
+	if async {
+		lazyfreeDict(old)
+	}
+}
+
+// FlushAll flushes every database.
+func FlushAll(async bool) {
+	for _, db := range databases {
+		db.Flush(async)
+	}
+}
+
+// Lookup returns the value stored at key, deleting it first if it has
+// expired. Expired fields of a hash are deleted too, along with the key if
+// no field is left. On a replica nothing is deleted: an expired key is
+// reported missing to clients, but not to the master.
+func (db *DB) Lookup(key string) (any, bool) {
+	if db.expireIfNeeded(key) {
+		return nil, false
+	}
+	v, ok := db.dict.Get(key)
+	if h, isHash := v.(*Hash); isHash {
+		// On a replica a hash whose fields have all expired is hidden
+		// like an expired key; the master deletes it.
+		if replica && h.Len() == 0 || !replica && db.expireHashFields(key, h, Now()) {
+			return nil, false
+		}
+	}
+	return v, ok
+}
+
+// Set stores v at key, discarding any previous value and its TTL.
+func (db *DB) Set(key string, v any) {
+	db.dict.Set(key, normalize(v))
+	db.expires.Delete(key)
+	db.trackHashExpires(key, v)
+}
+
+// Overwrite replaces the value of key but keeps its TTL, for commands such
+// as INCR that modify a value rather than replace the key.
+func (db *DB) Overwrite(key string, v any) {
+	db.dict.Set(key, normalize(v))
+	db.trackHashExpires(key, v)
+}
+
+// trackHashExpires lets the active expire cycle find v if it is a hash with
+// field TTLs.
+func (db *DB) trackHashExpires(key string, v any) {
+	if h, ok := v.(*Hash); ok && h.volatile() {
+		db.hashExpires.Set(key, struct{}{})
+	}
+}
+
+func normalize(v any) any {
+	if b, ok := v.([]byte); ok && b == nil {
+		return []byte{}
+	}
+	return v
+}
+
+func (db *DB) Delete(key string) bool {
+	if !db.dict.Delete(key) {
+		return false
+	}
+	db.expires.Delete(key)
+	return true
+}
+
+// Rename moves the value and TTL of src to dst, replacing whatever dst
+// held. src must exist.
+func (db *DB) Rename(src, dst string) {
+	v, _ := db.dict.Get(src)
+	at, hasTTL := db.expires.Get(src)
+	db.Delete(src)
+	db.Set(dst, v)
+	if hasTTL {
+		db.expires.Set(dst, at)
+	}
+}
+
+// SetExpire makes key disappear at the given unix time in milliseconds.
+func (db *DB) SetExpire(key string, at int64) {
+	if _, ok := db.dict.Get(key); ok {
+		db.expires.Set(key, at)
+	}
+}
+
+// SetFieldExpire makes field of the hash at key disappear at the given unix
+// time in milliseconds. The key must hold a hash with that field.
+func (db *DB) SetFieldExpire(key, field string, at int64) {
+	v, _ := db.dict.Get(key)
+	v.(*Hash).setFieldExpire(field, at)
+	db.hashExpires.Set(key, struct{}{})
+}
+
+// Expire returns the unix time in milliseconds at which key expires, and
+// false when the key has no TTL.
+func (db *DB) Expire(key string) (int64, bool) {
+	return db.expires.Get(key)
+}
+
+// Persist removes the TTL of key, reporting whether it had one.
+func (db *DB) Persist(key string) bool {
+	return db.expires.Delete(key)
+}
+
+// Scan visits the keys in the bucket the cursor points at; see Dict.Scan.
+func (db *DB) Scan(cursor uint64, fn func(key string, v any)) uint64 {
+	return db.dict.Scan(cursor, fn)
+}
+
+// Keys returns every key, expired or not.
+func (db *DB) Keys() []string {
+	keys := make([]string, 0, db.dict.Len())
+	db.dict.Range(func(key string, _ any) bool {
+		keys = append(keys, key)
+		return true
+	})
+	return keys
+}
+
+func (db *DB) Len() int {
+	return db.dict.Len()
+}
+
+// ExpiresLen returns the number of keys with a TTL.
+func (db *DB) ExpiresLen() int {
+	return db.expires.Len()
+}
+
+// AvgTTL returns the estimated average TTL of volatile keys in milliseconds.
+func (db *DB) AvgTTL() int64 {
+	return db.avgTTL
+}
+
+// expireIfNeeded reports whether key has expired, deleting it unless this
+// is a replica.
+func (db *DB) expireIfNeeded(key string) bool {
+	at, ok := db.expires.Get(key)
+	if !ok || at > Now() {
+		return false
+	}
+	if replica {
+		return !fromMaster
+	}
+	db.deleteExpired(key)
+	return true
+}
+
+// String returns the string stored at key, or nil when the key does not
+// exist. It fails with ErrWrongType when the key holds another type.
+func (db *DB) String(key string) ([]byte, error) {
+	v, ok := db.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := v.([]byte)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return s, nil
+}
+
+// List returns the list stored at key, or nil when the key does not exist.
+func (db *DB) List(key string) (*List, error) {
+	v, ok := db.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	l, ok := v.(*List)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return l, nil
+}
+
+// Hash returns the hash stored at key, or nil when the key does not exist.
+func (db *DB) Hash(key string) (*Hash, error) {
+	v, ok := db.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	h, ok := v.(*Hash)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return h, nil
+}
+
+// Stream returns the stream stored at key, or nil when the key does not
+// exist.
+func (db *DB) Stream(key string) (*Stream, error) {
+	v, ok := db.Lookup(key)
+	if !ok {
+		return nil, nil
+	}
+	s, ok := v.(*Stream)
+	if !ok {
+		return nil, ErrWrongType
+	}
+	return s, nil
+}
+
+// TypeOf returns the name TYPE reports for a stored value.
+func TypeOf(v any) string {
+	switch v.(type) {
+	case []byte:
+		return "string"
+	case *List:
+		return "list"
+	case *Hash:
+		return "hash"
+	case *Stream:
+		return "stream"
+	default:
+		return "none"
+	}
+}
+
+// Copy returns a deep copy of a stored value, for COPY.
+func Copy(v any) any {
+	switch v := v.(type) {
+	case []byte:
+		return append([]byte{}, v...)
+	case *List:
+		return v.Copy()
+	case *Hash:
+		return v.Copy()
+	case *Stream:
+		return v.Copy()
+	default:
+		return v
+	}
+}
+
+// Now returns the current unix time in milliseconds.
+func Now() int64 {
+	return time.Now().UnixMilli()
+}
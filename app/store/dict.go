@@ -0,0 +1,271 @@
+package store
+
+import (
+	"hash/maphash"
+	"math/bits"
+	"math/rand/v2"
+)
+
+const dictInitialSize = 4
+
+// dictRehashEmptyVisits bounds the empty buckets a rehash step may skip
+// over, so a step stays cheap in a sparse table.
+const dictRehashEmptyVisits = 10
+
+// Dict is a chained hash table with a power of two number of buckets, laid
+// out like the Redis dict so that it can be walked with a stateless cursor.
+// Go maps can only be iterated in one go, which SCAN cannot use.
+//
+// Like Redis it resizes incrementally: a new table is allocated next to the
+// old one and every Get, Set and Delete moves one bucket across, so no
+// single command pays for rehashing a large table.
+type Dict[V any] struct {
+	// table[1] is only in use while rehashing, when rehashIdx is the next
+	// bucket of table[0] to move. It is -1 otherwise.
+	table     [2][]*dictEntry[V]
+	rehashIdx int
+	// paused is non-zero while Range runs, which must not see entries
+	// move under it.
+	paused int
+	used   int
+	seed   maphash.Seed
+}
+
+type dictEntry[V any] struct {
+	key  string
+	val  V
+	next *dictEntry[V]
+}
+
+func NewDict[V any]() *Dict[V] {
+	return &Dict[V]{
+		table:     [2][]*dictEntry[V]{make([]*dictEntry[V], dictInitialSize)},
+		rehashIdx: -1,
+		seed:      maphash.MakeSeed(),
+	}
+}
+
+func (d *Dict[V]) Len() int {
+	return d.used
+}
+
+func (d *Dict[V]) Get(key string) (V, bool) {
+	d.rehashStep()
+	if e := d.find(key); e != nil {
+		return e.val, true
+	}
+	var zero V
+	return zero, false
+}
+
+// Set inserts key or replaces its value.
+func (d *Dict[V]) Set(key string, v V) {
+	d.rehashStep()
+	if e := d.find(key); e != nil {
+		e.val = v
+		return
+	}
+	// New entries go straight to the new table while rehashing.
+	t := d.table[0]
+	if d.rehashing() {
+		t = d.table[1]
+	}
+	b := d.bucket(t, key)
+	t[b] = &dictEntry[V]{key: key, val: v, next: t[b]}
+	d.used++
+	if !d.rehashing() && d.used > len(d.table[0]) {
+		d.resize(len(d.table[0]) * 2)
+	}
+}
+
+func (d *Dict[V]) Delete(key string) bool {
+	d.rehashStep()
+	for i := range d.table {
+		t := d.table[i]
+		if t == nil {
+			break
+		}
+		b := d.bucket(t, key)
+		for prev, e := (*dictEntry[V])(nil), t[b]; e != nil; prev, e = e, e.next {
+			if e.key != key {
+				continue
+			}
+			if prev == nil {
+				t[b] = e.next
+			} else {
+				prev.next = e.next
+			}
+			d.used--
+			if !d.rehashing() && len(d.table[0]) > dictInitialSize && d.used < len(d.table[0])/8 {
+				// Shrink all the way at once: halving would take
+				// a rehash per step while a large dict empties.
+				d.resize(max(dictInitialSize, 1<<bits.Len(uint(d.used))))
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Range calls fn for every entry until fn returns false. fn must not modify
+// the dict.
+func (d *Dict[V]) Range(fn func(key string, v V) bool) {
+	d.paused++
+	defer func() { d.paused-- }()
+	for _, t := range d.table {
+		for _, e := range t {
+			for ; e != nil; e = e.next {
+				if !fn(e.key, e.val) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Scan calls fn for every entry of the bucket the cursor points at and
+// returns the cursor of the next bucket, or 0 once the whole table has been
+// visited. Starting from 0 and feeding each returned cursor back in visits
+// every entry that is present for the whole iteration at least once, even
+// if the table is resized in between, because the cursor is incremented in
+// bit-reversed order: the buckets a bucket splits into or merges with when
+// the table doubles or halves are all visited together. While rehashing,
+// the bucket of the smaller table is visited together with every bucket of
+// the larger one it expands to. fn may delete entries.
+func (d *Dict[V]) Scan(cursor uint64, fn func(key string, v V)) uint64 {
+	var batch []*dictEntry[V]
+	collect := func(t []*dictEntry[V], b uint64) {
+		for e := t[b]; e != nil; e = e.next {
+			batch = append(batch, e)
+		}
+	}
+
+	if !d.rehashing() {
+		mask := uint64(len(d.table[0]) - 1)
+		collect(d.table[0], cursor&mask)
+		cursor = nextCursor(cursor, mask)
+	} else {
+		small, large := d.table[0], d.table[1]
+		if len(small) > len(large) {
+			small, large = large, small
+		}
+		m0, m1 := uint64(len(small)-1), uint64(len(large)-1)
+		collect(small, cursor&m0)
+		for {
+			collect(large, cursor&m1)
+			cursor = nextCursor(cursor, m1)
+			// Stop once the increment carries into the bits of the
+			// smaller table.
+			if cursor&(m0^m1) == 0 {
+				break
+			}
+		}
+	}
+
+	for _, e := range batch {
+		fn(e.key, e.val)
+	}
+	return cursor
+}
+
+// nextCursor increments the bits of cursor under mask in reverse order.
+func nextCursor(cursor, mask uint64) uint64 {
+	// Set the unmasked bits so incrementing the reversed cursor carries
+	// straight into the masked bits.
+	cursor |= ^mask
+	cursor = bits.Reverse64(cursor)
+	cursor++
+	return bits.Reverse64(cursor)
+}
+
+// RandomKey returns a random key, or false when the dict is empty.
+func (d *Dict[V]) RandomKey() (string, bool) {
+	if d.used == 0 {
+		return "", false
+	}
+	// Pick a random non-empty bucket, then a random entry in its chain.
+	// The buckets of the old table before rehashIdx are known to be
+	// empty while rehashing.
+	var head *dictEntry[V]
+	for head == nil {
+		if !d.rehashing() {
+			head = d.table[0][rand.IntN(len(d.table[0]))]
+			continue
+		}
+		n0 := len(d.table[0])
+		i := d.rehashIdx + rand.IntN(n0-d.rehashIdx+len(d.table[1]))
+		if i < n0 {
+			head = d.table[0][i]
+		} else {
+			head = d.table[1][i-n0]
+		}
+	}
+	n := 0
+	for e := head; e != nil; e = e.next {
+		n++
+	}
+	e := head
+	for i := rand.IntN(n); i > 0; i-- {
+		e = e.next
+	}
+	return e.key, true
+}
+
+func (d *Dict[V]) find(key string) *dictEntry[V] {
+	for _, t := range d.table {
+		if t == nil {
+			break
+		}
+		for e := t[d.bucket(t, key)]; e != nil; e = e.next {
+			if e.key == key {
+				return e
+			}
+		}
+	}
+	return nil
+}
+
+func (d *Dict[V]) bucket(t []*dictEntry[V], key string) uint64 {
+	return maphash.String(d.seed, key) & uint64(len(t)-1)
+}
+
+func (d *Dict[V]) rehashing() bool {
+	return d.rehashIdx >= 0
+}
+
+// resize starts moving the entries to a table of the given size.
+func (d *Dict[V]) resize(size int) {
+	d.table[1] = make([]*dictEntry[V], size)
+	d.rehashIdx = 0
+	d.rehashStep()
+}
+
+// rehashStep moves the next non-empty bucket of the old table to the new
+// one, and swaps them in once the old table is empty.
+func (d *Dict[V]) rehashStep() {
+	if !d.rehashing() || d.paused > 0 {
+		return
+	}
+	old, t := d.table[0], d.table[1]
+	for empty := 0; d.rehashIdx < len(old) && old[d.rehashIdx] == nil; empty++ {
+		if empty == dictRehashEmptyVisits {
+			return
+		}
+		d.rehashIdx++
+	}
+	if d.rehashIdx < len(old) {
+		for e := old[d.rehashIdx]; e != nil; {
+			next := e.next
+			b := d.bucket(t, e.key)
+			e.next = t[b]
+			t[b] = e
+			e = next
+		}
+		old[d.rehashIdx] = nil
+		d.rehashIdx++
+	}
+	if d.rehashIdx == len(old) {
+		d.table = [2][]*dictEntry[V]{t}
+		d.rehashIdx = -1
+	}
+}
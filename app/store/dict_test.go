@@ -0,0 +1,253 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestDict(t *testing.T) {
+	d := NewDict[int]()
+	const n = 1000
+	for i := 0; i < n; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	d.Set("7", 70)
+	if d.Len() != n {
+		t.Fatalf("Len() = %d, want %d", d.Len(), n)
+	}
+	for i := 0; i < n; i++ {
+		want := i
+		if i == 7 {
+			want = 70
+		}
+		if v, ok := d.Get(strconv.Itoa(i)); !ok || v != want {
+			t.Fatalf("Get(%d) = %d, %v, want %d", i, v, ok, want)
+		}
+	}
+	if _, ok := d.Get("missing"); ok {
+		t.Fatal("Get(missing) found a value")
+	}
+	for i := 0; i < n; i += 2 {
+		if !d.Delete(strconv.Itoa(i)) {
+			t.Fatalf("Delete(%d) = false", i)
+		}
+	}
+	if d.Delete("0") {
+		t.Fatal("deleted a key twice")
+	}
+	if d.Len() != n/2 {
+		t.Fatalf("Len() = %d after deleting half, want %d", d.Len(), n/2)
+	}
+	for i := 0; i < n; i++ {
+		if _, ok := d.Get(strconv.Itoa(i)); ok != (i%2 == 1) {
+			t.Fatalf("Get(%d) found = %v", i, ok)
+		}
+	}
+}
+
+func TestDictRandomKey(t *testing.T) {
+	d := NewDict[int]()
+	if _, ok := d.RandomKey(); ok {
+		t.Fatal("RandomKey() on an empty dict returned a key")
+	}
+	for i := 0; i < 10; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		key, ok := d.RandomKey()
+		if _, exists := d.Get(key); !ok || !exists {
+			t.Fatalf("RandomKey() = %q, %v", key, ok)
+		}
+		seen[key] = true
+	}
+	if len(seen) != 10 {
+		t.Errorf("1000 draws returned %d of 10 keys", len(seen))
+	}
+}
+
+// TestDictScan checks the SCAN guarantee: every key present for the whole
+// iteration is returned, however the table is resized between calls.
+func TestDictScan(t *testing.T) {
+	tests := []struct {
+		name string
+		// initial is the number of keys present throughout.
+		initial int
+		// temporary adds 10000 keys named tmp:N that between may delete.
+		temporary bool
+		// between runs after every Scan call, with the number of calls
+		// made so far.
+		between func(d *Dict[int], call int)
+	}{
+		{
+			name:    "no resize",
+			initial: 500,
+			between: func(*Dict[int], int) {},
+		},
+		{
+			name:    "grow",
+			initial: 100,
+			between: func(d *Dict[int], call int) {
+				// The first calls add enough keys to force a
+				// doubling every time.
+				if call > 8 {
+					return
+				}
+				for i := 0; i < 128<<call; i++ {
+					d.Set("new:"+strconv.Itoa(call)+":"+strconv.Itoa(i), 0)
+				}
+			},
+		},
+		{
+			name:      "shrink",
+			initial:   100,
+			temporary: true,
+			between: func(d *Dict[int], call int) {
+				if call == 1 {
+					for i := 0; i < 10000; i++ {
+						d.Delete("tmp:" + strconv.Itoa(i))
+					}
+				}
+			},
+		},
+		{
+			name:      "grow then shrink",
+			initial:   200,
+			temporary: true,
+			between: func(d *Dict[int], call int) {
+				switch call {
+				case 2:
+					for i := 0; i < 5000; i++ {
+						d.Set("tmp2:"+strconv.Itoa(i), 0)
+					}
+				case 6:
+					for i := 0; i < 10000; i++ {
+						d.Delete("tmp:" + strconv.Itoa(i))
+						d.Delete("tmp2:" + strconv.Itoa(i))
+					}
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := NewDict[int]()
+			for i := 0; i < tt.initial; i++ {
+				d.Set("key:"+strconv.Itoa(i), i)
+			}
+			if tt.temporary {
+				for i := 0; i < 10000; i++ {
+					d.Set("tmp:"+strconv.Itoa(i), 0)
+				}
+			}
+
+			seen := map[string]bool{}
+			cursor, calls := uint64(0), 0
+			for {
+				cursor = d.Scan(cursor, func(key string, _ int) {
+					seen[key] = true
+				})
+				calls++
+				if cursor == 0 {
+					break
+				}
+				if calls > 1_000_000 {
+					t.Fatal("scan did not terminate")
+				}
+				tt.between(d, calls)
+			}
+			for i := 0; i < tt.initial; i++ {
+				if key := "key:" + strconv.Itoa(i); !seen[key] {
+					t.Errorf("scan missed %s", key)
+				}
+			}
+		})
+	}
+}
+
+func TestDictScanDelete(t *testing.T) {
+	d := NewDict[int]()
+	for i := 0; i < 1000; i++ {
+		d.Set(strconv.Itoa(i), i)
+	}
+	cursor, visited := uint64(0), 0
+	for {
+		cursor = d.Scan(cursor, func(key string, _ int) {
+			visited++
+			d.Delete(key)
+		})
+		if cursor == 0 {
+			break
+		}
+	}
+	if visited < 1000 {
+		t.Errorf("visited %d of 1000 keys", visited)
+	}
+	if d.Len() != 0 {
+		t.Errorf("Len() = %d after deleting every key during the scan", d.Len())
+	}
+}
+
+// TestDictIncrementalRehash checks that a resize moves entries a bucket at
+// a time and that every operation sees them wherever they are meanwhile.
+func TestDictIncrementalRehash(t *testing.T) {
+	d := NewDict[int]()
+	n := 0
+	for !d.rehashing() || len(d.table[0]) < 1024 {
+		d.Set(strconv.Itoa(n), n)
+		n++
+	}
+	if d.rehashIdx > dictRehashEmptyVisits+1 {
+		t.Fatalf("growing moved %d buckets at once", d.rehashIdx)
+	}
+	// Move part of the table, then look at the dict half way through.
+	for i := 0; i < 100; i++ {
+		d.Get("missing")
+	}
+	if !d.rehashing() {
+		t.Fatal("rehash finished early")
+	}
+
+	check := func(when string) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			if v, ok := d.Get(strconv.Itoa(i)); !ok || v != i {
+				t.Fatalf("%s: Get(%d) = %d, %v", when, i, v, ok)
+			}
+		}
+		ranged := 0
+		d.Range(func(string, int) bool { ranged++; return true })
+		scanned := map[string]bool{}
+		for cursor := d.Scan(0, func(k string, _ int) { scanned[k] = true }); cursor != 0; {
+			cursor = d.Scan(cursor, func(k string, _ int) { scanned[k] = true })
+		}
+		if ranged != n || len(scanned) != n || d.Len() != n {
+			t.Fatalf("%s: Range saw %d, Scan %d, Len %d, want %d", when, ranged, len(scanned), d.Len(), n)
+		}
+		for i := 0; i < 100; i++ {
+			if k, ok := d.RandomKey(); !ok || !scanned[k] {
+				t.Fatalf("%s: RandomKey() = %q, %v", when, k, ok)
+			}
+		}
+	}
+	check("while rehashing")
+	if d.rehashing() {
+		t.Fatal("lookups did not finish the rehash")
+	}
+	check("after rehashing")
+
+	// Shrinking works the same way.
+	size := len(d.table[0])
+	for n > 10 {
+		n--
+		d.Delete(strconv.Itoa(n))
+	}
+	// Few keys are left to look up, so finish the rehash first.
+	for i := 0; d.rehashing() && i < size; i++ {
+		d.Get("missing")
+	}
+	check("after shrinking")
+	if len(d.table[0]) >= size/4 {
+		t.Errorf("%d buckets left of %d for %d keys", len(d.table[0]), size, n)
+	}
+}
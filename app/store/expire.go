@@ -0,0 +1,207 @@
+package store
+
+import "time"
+
+const (
+	activeExpireCycleKeysPerLoop     = 20                    // keys sampled per round
+	activeExpireCycleAcceptableStale = 10                    // % of expired keys at which we stop
+	activeExpireCycleTimeLimit       = 25 * time.Millisecond // budget per cycle
+	activeExpireCycleInterval        = 100 * time.Millisecond
+)
+
+// Propagate, when set, is called with the DEL command for every key that
+// expires, or the HDEL command for expired hash fields, and the database it
+// was in, so replicas drop them too.
+var Propagate func(db int, cmd [][]byte)
+
+// replica is set on a server that replicates a master. A replica never
+// expires keys or hash fields itself: the master sends a DEL or HDEL when
+// they expire there, and deleting them earlier could lose a key that a
+// delayed command from the master still expects to find.
+var replica bool
+
+// fromMaster is set while a command received from the master runs. Such
+// commands see expired keys that are still stored, as the master did.
+var fromMaster bool
+
+// SetReplica marks the server as a replica. It must be called before the
+// server starts serving clients.
+func SetReplica() {
+	replica = true
+}
+
+// SetFromMaster tells lookups whether the running command came from the
+// master. It must be called with the keyspace lock held.
+func SetFromMaster(b bool) {
+	fromMaster = b
+}
+
+// Stats holds the counters reported by INFO stats. It is guarded by the
+// keyspace lock.
+var Stats struct {
+	ExpiredKeys                int64
+	ExpiredSubkeys             int64
+	ExpiredStalePerc           float64
+	ExpiredTimeCapReachedCount int64
+	ExpireCycleCPUMilliseconds int64
+}
+
+// RunActiveExpire runs the active expire cycle every 100ms, forever. Without
+// it an expired key would only go away once a command touched it.
+func RunActiveExpire() {
+	ticker := time.NewTicker(activeExpireCycleInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		activeExpireCycle()
+	}
+}
+
+// activeExpireCycle works like the Redis one: it samples keys with a TTL,
+// deletes the expired ones, and samples again as long as more than 10% of a
+// sample was expired, giving up once the time budget is spent.
+func activeExpireCycle() {
+	mu.Lock()
+	defer mu.Unlock()
+
+	start := time.Now()
+	var sampled, expired int64
+	timedOut := false
+
+	for _, db := range databases {
+		if timedOut {
+			break
+		}
+		if activeExpireHashFields(db, start) {
+			timedOut = true
+			break
+		}
+		for iteration := 0; db.expires.Len() > 0; iteration++ {
+			// Walk the expires dict with a cursor that persists across
+			// cycles so every key with a TTL is eventually looked at.
+			now := Now()
+			n, e := 0, 0
+			var ttlSum, ttlSamples int64
+			for n < activeExpireCycleKeysPerLoop {
+				db.expiresCursor = db.expires.Scan(db.expiresCursor, func(key string, at int64) {
+					n++
+					if at <= now {
+						db.deleteExpired(key)
+						e++
+					} else {
+						ttlSum += at - now
+						ttlSamples++
+					}
+				})
+				if db.expiresCursor == 0 {
+					break
+				}
+			}
+			if n == 0 {
+				continue
+			}
+			sampled += int64(n)
+			expired += int64(e)
+
+			if ttlSamples > 0 {
+				avg := ttlSum / ttlSamples
+				if db.avgTTL == 0 {
+					db.avgTTL = avg
+				} else {
+					db.avgTTL = avg/50 + db.avgTTL/50*49
+				}
+			}
+
+			if iteration%16 == 0 && time.Since(start) > activeExpireCycleTimeLimit {
+				Stats.ExpiredTimeCapReachedCount++
+				timedOut = true
+				break
+			}
+			if e*100/n <= activeExpireCycleAcceptableStale {
+				break
+			}
+		}
+	}
+
+	Stats.ExpireCycleCPUMilliseconds += time.Since(start).Milliseconds()
+	current := 0.0
+	if sampled > 0 {
+		current = float64(expired) / float64(sampled)
+	}
+	Stats.ExpiredStalePerc = current*0.05 + Stats.ExpiredStalePerc*0.95
+}
+
+// activeExpireHashFields deletes the expired fields of hashes with field
+// TTLs, sampling them the same way as keys. It reports whether the time
+// budget of the cycle starting at start ran out.
+func activeExpireHashFields(db *DB, start time.Time) bool {
+	for iteration := 0; db.hashExpires.Len() > 0; iteration++ {
+		now := Now()
+		n, e := 0, 0
+		for n < activeExpireCycleKeysPerLoop {
+			db.hashExpiresCursor = db.hashExpires.Scan(db.hashExpiresCursor, func(key string, _ struct{}) {
+				n++
+				v, _ := db.dict.Get(key)
+				h, ok := v.(*Hash)
+				if !ok || !h.volatile() {
+					// The key was deleted or replaced, or its
+					// fields were persisted.
+					db.hashExpires.Delete(key)
+					return
+				}
+				if h.nextExpire > now {
+					return
+				}
+				e++
+				if db.expireHashFields(key, h, now) || !h.volatile() {
+					db.hashExpires.Delete(key)
+				}
+			})
+			if db.hashExpiresCursor == 0 {
+				break
+			}
+		}
+		if n == 0 {
+			return false
+		}
+		if iteration%16 == 0 && time.Since(start) > activeExpireCycleTimeLimit {
+			Stats.ExpiredTimeCapReachedCount++
+			return true
+		}
+		if e*100/n <= activeExpireCycleAcceptableStale {
+			return false
+		}
+	}
+	return false
+}
+
+// expireHashFields deletes the fields of the hash h at key whose TTL is at
+// or before now and tells the replicas, deleting the key when no field is
+// left. It reports whether the key was deleted.
+func (db *DB) expireHashFields(key string, h *Hash, now int64) bool {
+	fields := h.expireFields(now)
+	if len(fields) == 0 {
+		return false
+	}
+	Stats.ExpiredSubkeys += int64(len(fields))
+	if h.Len() == 0 {
+		db.deleteExpired(key)
+		return true
+	}
+	if Propagate != nil {
+		argv := [][]byte{[]byte("HDEL"), []byte(key)}
+		for _, field := range fields {
+			argv = append(argv, []byte(field))
+		}
+		Propagate(db.ID, argv)
+	}
+	return false
+}
+
+// deleteExpired removes a key whose TTL has passed and tells the replicas.
+func (db *DB) deleteExpired(key string) {
+	db.Delete(key)
+	Stats.ExpiredKeys++
+	if Propagate != nil {
+		Propagate(db.ID, [][]byte{[]byte("DEL"), []byte(key)})
+	}
+}
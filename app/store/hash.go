@@ -0,0 +1,277 @@
+package store
+
+import (
+	"math"
+	"math/rand/v2"
+)
+
+// Small hashes are kept as a flat slice of field-value pairs, like the Redis
+// listpack encoding: scanning a few dozen pairs is as fast as hashing and
+// takes far less memory. A hash is converted to a Dict for good once it has
+// more than hashMaxListpackEntries fields or a field or value longer than
+// hashMaxListpackValue bytes, the Redis defaults.
+const (
+	hashMaxListpackEntries = 128
+	hashMaxListpackValue   = 64
+)
+
+// Hash is the value of a hash key. Fields may have a TTL of their own, the
+// unix time in milliseconds at which they disappear, kept in expires.
+type Hash struct {
+	// pairs holds the fields while the hash is small, dict afterwards.
+	pairs []hashPair
+	dict  *Dict[string]
+
+	// expires is nil until a field gets a TTL. nextExpire is no later than
+	// the earliest TTL in it, so hashes with nothing due are skipped
+	// without looking at their fields.
+	expires    map[string]int64
+	nextExpire int64
+}
+
+type hashPair struct {
+	field, value string
+}
+
+func NewHash() *Hash {
+	return &Hash{}
+}
+
+// Len returns the number of fields, leaving out the ones a replica is
+// hiding.
+func (h *Hash) Len() int {
+	n := len(h.pairs)
+	if h.dict != nil {
+		n = h.dict.Len()
+	}
+	if h.hiding() {
+		now := Now()
+		for _, at := range h.expires {
+			if at <= now {
+				n--
+			}
+		}
+	}
+	return n
+}
+
+func (h *Hash) Get(field string) (string, bool) {
+	if h.hidden(field) {
+		return "", false
+	}
+	if h.dict != nil {
+		return h.dict.Get(field)
+	}
+	if i := h.find(field); i >= 0 {
+		return h.pairs[i].value, true
+	}
+	return "", false
+}
+
+// Set sets field to value, discarding any TTL it had, and reports whether
+// the field is new.
+func (h *Hash) Set(field, value string) bool {
+	h.PersistField(field)
+	return h.Overwrite(field, value)
+}
+
+// Overwrite is Set but keeps the TTL of an existing field, for commands
+// such as HINCRBY that modify a value rather than replace the field.
+func (h *Hash) Overwrite(field, value string) bool {
+	if h.dict == nil {
+		if i := h.find(field); i >= 0 {
+			h.pairs[i].value = value
+			h.convertIfLong(field, value)
+			return false
+		}
+		h.pairs = append(h.pairs, hashPair{field, value})
+		h.convertIfLong(field, value)
+		if len(h.pairs) > hashMaxListpackEntries {
+			h.convert()
+		}
+		return true
+	}
+	_, exists := h.dict.Get(field)
+	h.dict.Set(field, value)
+	return !exists
+}
+
+// Delete removes field and reports whether it existed.
+func (h *Hash) Delete(field string) bool {
+	h.PersistField(field)
+	if h.dict != nil {
+		return h.dict.Delete(field)
+	}
+	i := h.find(field)
+	if i < 0 {
+		return false
+	}
+	last := len(h.pairs) - 1
+	copy(h.pairs[i:], h.pairs[i+1:])
+	h.pairs[last] = hashPair{}
+	h.pairs = h.pairs[:last]
+	return true
+}
+
+// Range calls fn for every field until fn returns false. fn must not modify
+// the hash.
+func (h *Hash) Range(fn func(field, value string) bool) {
+	if h.hiding() {
+		visible := fn
+		fn = func(field, value string) bool {
+			return h.hidden(field) || visible(field, value)
+		}
+	}
+	if h.dict != nil {
+		h.dict.Range(fn)
+		return
+	}
+	for _, p := range h.pairs {
+		if !fn(p.field, p.value) {
+			return
+		}
+	}
+}
+
+// Random returns a random field and its value. The hash must not be empty.
+func (h *Hash) Random() (string, string) {
+	if h.hiding() {
+		var live []hashPair
+		h.Range(func(field, value string) bool {
+			live = append(live, hashPair{field, value})
+			return true
+		})
+		p := live[rand.IntN(len(live))]
+		return p.field, p.value
+	}
+	if h.dict != nil {
+		field, _ := h.dict.RandomKey()
+		value, _ := h.dict.Get(field)
+		return field, value
+	}
+	p := h.pairs[rand.IntN(len(h.pairs))]
+	return p.field, p.value
+}
+
+func (h *Hash) Copy() *Hash {
+	cp := NewHash()
+	if h.expires != nil {
+		cp.expires = make(map[string]int64, len(h.expires))
+		for field, at := range h.expires {
+			cp.expires[field] = at
+		}
+		cp.nextExpire = h.nextExpire
+	}
+	if h.dict == nil {
+		cp.pairs = append([]hashPair(nil), h.pairs...)
+		return cp
+	}
+	cp.dict = NewDict[string]()
+	h.dict.Range(func(field, value string) bool {
+		cp.dict.Set(field, value)
+		return true
+	})
+	return cp
+}
+
+// setFieldExpire makes field, which must exist, disappear at the given unix
+// time in milliseconds. Use DB.SetFieldExpire so the active expire cycle
+// learns about the hash.
+func (h *Hash) setFieldExpire(field string, at int64) {
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+		h.nextExpire = at
+	}
+	h.expires[field] = at
+	h.nextExpire = min(h.nextExpire, at)
+}
+
+// FieldExpire returns the unix time in milliseconds at which field expires,
+// and false when it has no TTL.
+func (h *Hash) FieldExpire(field string) (int64, bool) {
+	at, ok := h.expires[field]
+	return at, ok
+}
+
+// PersistField removes the TTL of field, reporting whether it had one.
+func (h *Hash) PersistField(field string) bool {
+	if _, ok := h.expires[field]; !ok {
+		return false
+	}
+	delete(h.expires, field)
+	if len(h.expires) == 0 {
+		h.expires = nil
+	}
+	return true
+}
+
+// hiding reports whether some field may have passed its TTL on a replica.
+// A replica keeps such fields until the master sends an HDEL, like it keeps
+// expired keys, but only the master's commands get to see them.
+func (h *Hash) hiding() bool {
+	return replica && !fromMaster && h.expires != nil && h.nextExpire <= Now()
+}
+
+// hidden reports whether field is one of the fields hiding is about.
+func (h *Hash) hidden(field string) bool {
+	if !h.hiding() {
+		return false
+	}
+	at, ok := h.expires[field]
+	return ok && at <= Now()
+}
+
+// volatile reports whether any field has a TTL.
+func (h *Hash) volatile() bool {
+	return h.expires != nil
+}
+
+// expireFields deletes the fields whose TTL is at or before now and returns
+// their names.
+func (h *Hash) expireFields(now int64) []string {
+	if h.expires == nil || h.nextExpire > now {
+		return nil
+	}
+	var expired []string
+	next := int64(math.MaxInt64)
+	for field, at := range h.expires {
+		if at <= now {
+			expired = append(expired, field)
+		} else {
+			next = min(next, at)
+		}
+	}
+	for _, field := range expired {
+		h.Delete(field)
+	}
+	h.nextExpire = next
+	return expired
+}
+
+func (h *Hash) find(field string) int {
+	for i, p := range h.pairs {
+		if p.field == field {
+			return i
+		}
+	}
+	return -1
+}
+
+func (h *Hash) convertIfLong(field, value string) {
+	if len(field) > hashMaxListpackValue || len(value) > hashMaxListpackValue {
+		h.convert()
+	}
+}
+
+func (h *Hash) convert() {
+	h.dict = NewDict[string]()
+	for _, p := range h.pairs {
+		h.dict.Set(p.field, p.value)
+	}
+	h.pairs = nil
+}
+
+// release drops every field so they can be collected.
+func (h *Hash) release() {
+	h.pairs, h.dict, h.expires = nil, nil, nil
+}
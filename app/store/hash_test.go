@@ -0,0 +1,107 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+// newVolatileHash stores a hash at key with fields a, b and c, of which a
+// and b have already expired, and returns it.
+func newVolatileHash(db *DB, key string) *Hash {
+	h := NewHash()
+	for _, f := range []string{"a", "b", "c"} {
+		h.Set(f, "v"+f)
+	}
+	db.Set(key, h)
+	db.SetFieldExpire(key, "a", Now()-1000)
+	db.SetFieldExpire(key, "b", Now()-1000)
+	db.SetFieldExpire(key, "c", Now()+100000)
+	return h
+}
+
+func TestHashFieldsExpire(t *testing.T) {
+	db := Select(0)
+	defer db.Delete("hash:master")
+	h := newVolatileHash(db, "hash:master")
+
+	if _, ok := db.Lookup("hash:master"); !ok {
+		t.Fatal("hash with a live field is missing")
+	}
+	if h.Len() != 1 || h.raw() != 1 {
+		t.Fatalf("Len() = %d with %d stored, want both 1", h.Len(), h.raw())
+	}
+	db.SetFieldExpire("hash:master", "c", Now()-1)
+	if _, ok := db.Lookup("hash:master"); ok {
+		t.Fatal("hash with every field expired still found")
+	}
+	if _, ok := db.dict.Get("hash:master"); ok {
+		t.Fatal("hash with every field expired still stored")
+	}
+}
+
+func TestHashFieldsExpireOnReplica(t *testing.T) {
+	replica = true
+	defer func() { replica = false }()
+	db := Select(0)
+	defer db.Delete("hash:replica")
+
+	// Large enough to be kept in a Dict as well as in pairs.
+	for _, extra := range []int{0, hashMaxListpackEntries} {
+		h := newVolatileHash(db, "hash:replica")
+		for i := 0; i < extra; i++ {
+			h.Set("extra:"+strconv.Itoa(i), "x")
+		}
+
+		if _, ok := db.Lookup("hash:replica"); !ok {
+			t.Fatal("hash with a live field is missing")
+		}
+		if _, ok := h.Get("a"); ok {
+			t.Error("Get returned an expired field")
+		}
+		if v, ok := h.Get("c"); !ok || v != "vc" {
+			t.Errorf("Get(c) = %q, %v", v, ok)
+		}
+		if h.Len() != 1+extra {
+			t.Errorf("Len() = %d, want %d", h.Len(), 1+extra)
+		}
+		h.Range(func(field, _ string) bool {
+			if field == "a" || field == "b" {
+				t.Errorf("Range visited expired field %s", field)
+			}
+			return true
+		})
+		for i := 0; i < 20; i++ {
+			if field, _ := h.Random(); field == "a" || field == "b" {
+				t.Fatalf("Random returned expired field %s", field)
+			}
+		}
+		if h.raw() != 3+extra {
+			t.Errorf("%d fields stored, want the expired ones kept", h.raw())
+		}
+
+		// The master's commands still see them.
+		SetFromMaster(true)
+		if _, ok := h.Get("a"); !ok || h.Len() != 3+extra {
+			t.Error("expired field hidden from the master")
+		}
+		SetFromMaster(false)
+
+		db.SetFieldExpire("hash:replica", "c", Now()-1)
+		if extra == 0 {
+			if _, ok := db.Lookup("hash:replica"); ok {
+				t.Error("hash with every field expired still found")
+			}
+		}
+		if _, ok := db.dict.Get("hash:replica"); !ok {
+			t.Error("replica deleted an expired hash")
+		}
+	}
+}
+
+// raw returns the number of fields stored, hidden or not.
+func (h *Hash) raw() int {
+	if h.dict != nil {
+		return h.dict.Len()
+	}
+	return len(h.pairs)
+}
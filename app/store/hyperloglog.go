@@ -0,0 +1,375 @@
+package store
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"math/bits"
+)
+
+// HyperLogLogs are strings laid out exactly like Redis's, so a value can be
+// moved between servers with GET and SET:
+//
+//	+------+---+-----+----------+
+//	| HYLL | E | N/U | Cardin.  |
+//	+------+---+-----+----------+
+//
+// a 4 byte magic, the encoding (dense or sparse), 3 unused bytes and the
+// cached cardinality as 8 little endian bytes. The most significant bit of
+// the last byte set means the cache is stale. The registers follow.
+//
+// Dense registers are 6 bits each, packed starting from the least
+// significant bit of each byte. Sparse registers are a run length encoding
+// of opcodes:
+//
+//	00xxxxxx           ZERO:  1 to 64 registers set to 0
+//	01xxxxxx yyyyyyyy  XZERO: 1 to 16384 registers set to 0
+//	1vvvvvxx           VAL:   1 to 4 registers set to 1 to 32
+const (
+	hllP         = 14
+	hllQ         = 64 - hllP
+	hllRegisters = 1 << hllP
+	hllPMask     = hllRegisters - 1
+	hllBits      = 6
+	hllRegMax    = 1<<hllBits - 1
+	hllHdrSize   = 16
+	hllDenseSize = hllHdrSize + (hllRegisters*hllBits+7)/8
+
+	hllDense  = 0
+	hllSparse = 1
+
+	hllSparseValMaxValue = 32
+	hllSparseValMaxLen   = 4
+	hllSparseZeroMaxLen  = 64
+	hllSparseXZeroMaxLen = 16384
+
+	// hllSparseMaxBytes is the size past which a sparse HyperLogLog is
+	// converted to dense, the default hll-sparse-max-bytes.
+	hllSparseMaxBytes = 3000
+
+	hllAlphaInf = 0.721347520444481703680 // 0.5/ln(2)
+)
+
+var (
+	ErrNotHLL     = errors.New("WRONGTYPE Key is not a valid HyperLogLog string value.")
+	ErrHLLCorrupt = errors.New("INVALIDOBJ Corrupted HLL object detected")
+)
+
+// HLLRegisters holds one HyperLogLog's registers unpacked, one per byte.
+type HLLRegisters [hllRegisters]uint8
+
+// NewHLL returns an empty sparse HyperLogLog.
+func NewHLL() []byte {
+	v := make([]byte, hllHdrSize, hllHdrSize+2)
+	copy(v, "HYLL")
+	v[4] = hllSparse
+	// A single XZERO opcode covering every register.
+	return append(v, 0x40|byte((hllRegisters-1)>>8), byte((hllRegisters-1)&0xff))
+}
+
+// CheckHLL reports whether v looks like a HyperLogLog.
+func CheckHLL(v []byte) error {
+	if len(v) < hllHdrSize || string(v[:4]) != "HYLL" || v[4] > hllSparse {
+		return ErrNotHLL
+	}
+	if v[4] == hllDense && len(v) != hllDenseSize {
+		return ErrNotHLL
+	}
+	return nil
+}
+
+// HLLAdd adds elements to the HyperLogLog v and returns it, along with
+// whether any register changed. The returned slice replaces v, which may
+// have been converted to the dense encoding.
+func HLLAdd(v []byte, elems [][]byte) ([]byte, bool, error) {
+	if v[4] == hllDense {
+		changed := false
+		for _, e := range elems {
+			index, count := hllPatLen(e)
+			if denseGet(v[hllHdrSize:], index) < count {
+				denseSet(v[hllHdrSize:], index, count)
+				changed = true
+			}
+		}
+		if changed {
+			hllInvalidateCache(v)
+		}
+		return v, changed, nil
+	}
+
+	// Sparse values are small: unpack them, apply the changes and pack
+	// them again, going dense when they no longer fit.
+	var regs HLLRegisters
+	if err := sparseDecode(v[hllHdrSize:], &regs); err != nil {
+		return v, false, err
+	}
+	changed := false
+	for _, e := range elems {
+		index, count := hllPatLen(e)
+		if regs[index] < count {
+			regs[index] = count
+			changed = true
+		}
+	}
+	if !changed {
+		return v, false, nil
+	}
+	return HLLFromRegisters(&regs, true), true, nil
+}
+
+// HLLCount returns the estimated cardinality of v, refreshing the cached
+// value stored in it when stale.
+func HLLCount(v []byte) (int64, error) {
+	if v[15]&0x80 == 0 {
+		return int64(binary.LittleEndian.Uint64(v[8:16])), nil
+	}
+	var regs HLLRegisters
+	if err := HLLMerge(&regs, v); err != nil {
+		return 0, err
+	}
+	n := HLLCountRegisters(&regs)
+	binary.LittleEndian.PutUint64(v[8:16], uint64(n))
+	return n, nil
+}
+
+// HLLMerge sets every register in regs to the larger of its value and the
+// one in v.
+func HLLMerge(regs *HLLRegisters, v []byte) error {
+	if v[4] == hllDense {
+		for i := range regs {
+			regs[i] = max(regs[i], denseGet(v[hllHdrSize:], i))
+		}
+		return nil
+	}
+	var other HLLRegisters
+	if err := sparseDecode(v[hllHdrSize:], &other); err != nil {
+		return err
+	}
+	for i := range regs {
+		regs[i] = max(regs[i], other[i])
+	}
+	return nil
+}
+
+// IsDenseHLL reports whether v uses the dense encoding.
+func IsDenseHLL(v []byte) bool {
+	return v[4] == hllDense
+}
+
+// HLLFromRegisters encodes regs as a HyperLogLog with a stale cache, sparse
+// if allowed and it fits, dense otherwise.
+func HLLFromRegisters(regs *HLLRegisters, sparse bool) []byte {
+	if sparse {
+		if v := sparseEncode(regs); v != nil {
+			return v
+		}
+	}
+	v := make([]byte, hllDenseSize)
+	copy(v, "HYLL")
+	v[4] = hllDense
+	hllInvalidateCache(v)
+	for i, r := range regs {
+		if r != 0 {
+			denseSet(v[hllHdrSize:], i, r)
+		}
+	}
+	return v
+}
+
+// HLLCountRegisters estimates the cardinality of regs with the improved
+// estimator from Otmar Ertl's "New cardinality estimation algorithms for
+// HyperLogLog sketches", as Redis does.
+func HLLCountRegisters(regs *HLLRegisters) int64 {
+	var histo [64]int
+	for _, r := range regs {
+		histo[r]++
+	}
+	m := float64(hllRegisters)
+	z := m * hllTau((m-float64(histo[hllQ+1]))/m)
+	for j := hllQ; j >= 1; j-- {
+		z += float64(histo[j])
+		z *= 0.5
+	}
+	z += m * hllSigma(float64(histo[0])/m)
+	return int64(math.Round(hllAlphaInf * m * m / z))
+}
+
+func hllInvalidateCache(v []byte) {
+	v[15] |= 0x80
+}
+
+// hllPatLen hashes an element and returns the register it maps to and the
+// length of the run of zeros it observed, plus one.
+func hllPatLen(elem []byte) (int, uint8) {
+	hash := murmurHash64A(elem, 0xadc83b19)
+	index := int(hash & hllPMask)
+	hash >>= hllP
+	// Bound the run so it fits a register.
+	hash |= 1 << hllQ
+	return index, uint8(bits.TrailingZeros64(hash) + 1)
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1 {
+		return math.Inf(1)
+	}
+	y, z := 1.0, x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if prev == z {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0 || x == 1 {
+		return 0
+	}
+	y, z := 1.0, 1-x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1 - x) * (1 - x) * y
+		if prev == z {
+			return z / 3
+		}
+	}
+}
+
+// denseGet reads register i of packed dense registers.
+func denseGet(p []byte, i int) uint8 {
+	b := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	v := p[b] >> fb
+	// The last register doesn't straddle a byte boundary.
+	if b+1 < len(p) {
+		v |= p[b+1] << (8 - fb)
+	}
+	return v & hllRegMax
+}
+
+func denseSet(p []byte, i int, val uint8) {
+	b := i * hllBits / 8
+	fb := uint(i*hllBits) & 7
+	p[b] &^= hllRegMax << fb
+	p[b] |= val << fb
+	if b+1 < len(p) {
+		p[b+1] &^= hllRegMax >> (8 - fb)
+		p[b+1] |= val >> (8 - fb)
+	}
+}
+
+// sparseDecode unpacks sparse registers into regs, which must be zeroed.
+func sparseDecode(p []byte, regs *HLLRegisters) error {
+	idx := 0
+	for i := 0; i < len(p); i++ {
+		op := p[i]
+		switch {
+		case op&0xc0 == 0x00: // ZERO
+			idx += int(op&0x3f) + 1
+		case op&0xc0 == 0x40: // XZERO
+			if i+1 >= len(p) {
+				return ErrHLLCorrupt
+			}
+			idx += (int(op&0x3f)<<8 | int(p[i+1])) + 1
+			i++
+		default: // VAL
+			val := (op>>2)&0x1f + 1
+			n := int(op&0x3) + 1
+			if idx+n > hllRegisters {
+				return ErrHLLCorrupt
+			}
+			for j := 0; j < n; j++ {
+				regs[idx+j] = val
+			}
+			idx += n
+		}
+		if idx > hllRegisters {
+			return ErrHLLCorrupt
+		}
+	}
+	if idx != hllRegisters {
+		return ErrHLLCorrupt
+	}
+	return nil
+}
+
+// sparseEncode packs regs into a sparse HyperLogLog, or returns nil when a
+// register is too large for a VAL opcode or the result would be larger
+// than hllSparseMaxBytes.
+func sparseEncode(regs *HLLRegisters) []byte {
+	v := make([]byte, hllHdrSize, 64)
+	copy(v, "HYLL")
+	v[4] = hllSparse
+	hllInvalidateCache(v)
+
+	for i := 0; i < hllRegisters; {
+		val := regs[i]
+		run := 1
+		for i+run < hllRegisters && regs[i+run] == val {
+			run++
+		}
+		i += run
+
+		switch {
+		case val == 0:
+			for run > 0 {
+				if run > hllSparseZeroMaxLen {
+					n := min(run, hllSparseXZeroMaxLen)
+					v = append(v, 0x40|byte((n-1)>>8), byte((n-1)&0xff))
+					run -= n
+				} else {
+					v = append(v, byte(run-1))
+					run = 0
+				}
+			}
+		case val > hllSparseValMaxValue:
+			return nil
+		default:
+			for run > 0 {
+				n := min(run, hllSparseValMaxLen)
+				v = append(v, 0x80|(val-1)<<2|byte(n-1))
+				run -= n
+			}
+		}
+		if len(v) > hllSparseMaxBytes {
+			return nil
+		}
+	}
+	return v
+}
+
+// murmurHash64A is MurmurHash2 for 64 bit platforms, reading the input as
+// little endian words so every machine gets the same hash.
+func murmurHash64A(key []byte, seed uint64) uint64 {
+	const m = 0xc6a4a7935bd1e995
+	const r = 47
+
+	h := seed ^ uint64(len(key))*m
+	for len(key) >= 8 {
+		k := binary.LittleEndian.Uint64(key)
+		k *= m
+		k ^= k >> r
+		k *= m
+		h ^= k
+		h *= m
+		key = key[8:]
+	}
+
+	if len(key) > 0 {
+		for i := len(key) - 1; i >= 0; i-- {
+			h ^= uint64(key[i]) << (8 * uint(i))
+		}
+		h *= m
+	}
+
+	h ^= h >> r
+	h *= m
+	h ^= h >> r
+	return h
+}
@@ -0,0 +1,212 @@
+package store
+
+import (
+	"errors"
+	"math/rand/v2"
+	"strconv"
+	"testing"
+)
+
+func TestHLLSmallCounts(t *testing.T) {
+	v := NewHLL()
+	if err := CheckHLL(v); err != nil {
+		t.Fatalf("CheckHLL(NewHLL()) = %v", err)
+	}
+	if n, err := HLLCount(v); err != nil || n != 0 {
+		t.Fatalf("HLLCount(NewHLL()) = %d, %v", n, err)
+	}
+	for i := 1; i <= 10; i++ {
+		var changed bool
+		var err error
+		v, changed, err = HLLAdd(v, [][]byte{[]byte(strconv.Itoa(i))})
+		if err != nil || !changed {
+			t.Fatalf("HLLAdd(%d) = %v, %v", i, changed, err)
+		}
+		if n, err := HLLCount(v); err != nil || n != int64(i) {
+			t.Fatalf("HLLCount after %d elements = %d, %v", i, n, err)
+		}
+	}
+	if _, changed, _ := HLLAdd(v, [][]byte{[]byte("1"), []byte("2")}); changed {
+		t.Error("adding elements already counted changed a register")
+	}
+}
+
+// TestHLLCount adds elements one batch at a time, through the sparse
+// encoding and past its switch to dense, checking the estimate against the
+// true cardinality and against a HyperLogLog that was dense from the start.
+func TestHLLCount(t *testing.T) {
+	checkpoints := []int{100, 500, 1000, 2000, 3000, 5000, 10000, 50000, 100000, 300000}
+
+	v := NewHLL()
+	var dense HLLRegisters
+	wasSparse, wasDense := false, false
+	added := 0
+	for _, n := range checkpoints {
+		var batch [][]byte
+		for ; added < n; added++ {
+			batch = append(batch, []byte("element:"+strconv.Itoa(added)))
+			if len(batch) == 100 {
+				v = addHLL(t, v, batch)
+				batch = batch[:0]
+			}
+		}
+		v = addHLL(t, v, batch)
+
+		if IsDenseHLL(v) {
+			wasDense = true
+		} else {
+			wasSparse = true
+		}
+		got, err := HLLCount(v)
+		if err != nil {
+			t.Fatalf("HLLCount at %d: %v", n, err)
+		}
+		if diff := got - int64(n); diff*100 > int64(n)*5 || diff*100 < -int64(n)*5 {
+			t.Errorf("HLLCount = %d for %d elements, more than 5%% off (dense %v)", got, n, IsDenseHLL(v))
+		}
+
+		dense = HLLRegisters{}
+		if err := HLLMerge(&dense, v); err != nil {
+			t.Fatal(err)
+		}
+		if want := HLLCountRegisters(&dense); got != want {
+			t.Errorf("HLLCount = %d at %d elements, but %d from the unpacked registers", got, n, want)
+		}
+		asDense, err := HLLCount(HLLFromRegisters(&dense, false))
+		if err != nil || asDense != got {
+			t.Errorf("dense copy counts %d, %v at %d elements, want %d", asDense, err, n, got)
+		}
+	}
+	if !wasSparse || !wasDense {
+		t.Errorf("never switched encodings: sparse %v, dense %v", wasSparse, wasDense)
+	}
+}
+
+func addHLL(t *testing.T, v []byte, elems [][]byte) []byte {
+	t.Helper()
+	v, _, err := HLLAdd(v, elems)
+	if err != nil {
+		t.Fatalf("HLLAdd: %v", err)
+	}
+	if err := CheckHLL(v); err != nil {
+		t.Fatalf("CheckHLL after HLLAdd: %v", err)
+	}
+	return v
+}
+
+func TestHLLCountCache(t *testing.T) {
+	v, _, _ := HLLAdd(NewHLL(), [][]byte{[]byte("a"), []byte("b"), []byte("c")})
+	if v[15]&0x80 == 0 {
+		t.Fatal("cache valid after HLLAdd changed a register")
+	}
+	n, _ := HLLCount(v)
+	if v[15]&0x80 != 0 {
+		t.Fatal("cache still stale after HLLCount")
+	}
+	if cached, _ := HLLCount(v); cached != n {
+		t.Errorf("cached count %d, computed %d", cached, n)
+	}
+	v, _, _ = HLLAdd(v, [][]byte{[]byte("d")})
+	if n, _ := HLLCount(v); n != 4 {
+		t.Errorf("HLLCount after invalidation = %d, want 4", n)
+	}
+}
+
+func TestHLLEncodingRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	tests := []struct {
+		name string
+		fill func(regs *HLLRegisters)
+		// sparse is whether the registers fit the sparse encoding.
+		sparse bool
+	}{
+		{"empty", func(*HLLRegisters) {}, true},
+		{"first and last", func(regs *HLLRegisters) {
+			regs[0], regs[hllRegisters-1] = 1, 32
+		}, true},
+		{"long runs", func(regs *HLLRegisters) {
+			for i := 1000; i < 1100; i++ {
+				regs[i] = 3
+			}
+		}, true},
+		{"value too large for sparse", func(regs *HLLRegisters) {
+			regs[42] = 33
+		}, false},
+		{"too many values for sparse", func(regs *HLLRegisters) {
+			for i := range regs {
+				regs[i] = uint8(r.IntN(6))
+			}
+		}, false},
+		{"every value", func(regs *HLLRegisters) {
+			for i := range regs {
+				regs[i] = uint8(i % (hllRegMax + 1))
+			}
+		}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var regs HLLRegisters
+			tt.fill(&regs)
+			for _, allowSparse := range []bool{true, false} {
+				v := HLLFromRegisters(&regs, allowSparse)
+				if err := CheckHLL(v); err != nil {
+					t.Fatalf("CheckHLL: %v", err)
+				}
+				if wantDense := !allowSparse || !tt.sparse; IsDenseHLL(v) != wantDense {
+					t.Errorf("sparse allowed %v: dense = %v, want %v", allowSparse, IsDenseHLL(v), wantDense)
+				}
+				if !IsDenseHLL(v) && len(v) > hllSparseMaxBytes {
+					t.Errorf("sparse encoding is %d bytes", len(v))
+				}
+				var got HLLRegisters
+				if err := HLLMerge(&got, v); err != nil {
+					t.Fatalf("HLLMerge: %v", err)
+				}
+				if got != regs {
+					t.Errorf("sparse allowed %v: registers changed in the round trip", allowSparse)
+				}
+			}
+		})
+	}
+}
+
+func TestHLLCorrupt(t *testing.T) {
+	header := func(encoding byte) []byte {
+		v := make([]byte, hllHdrSize)
+		copy(v, "HYLL")
+		v[4] = encoding
+		v[15] = 0x80
+		return v
+	}
+	notHLL := [][]byte{
+		[]byte("hello"),
+		append([]byte("HYLX"), make([]byte, 12)...),
+		header(2),
+		header(hllDense), // no registers
+	}
+	for _, v := range notHLL {
+		if err := CheckHLL(v); !errors.Is(err, ErrNotHLL) {
+			t.Errorf("CheckHLL(%q) = %v", v, err)
+		}
+	}
+
+	corrupt := map[string][]byte{
+		"too few registers":     append(header(hllSparse), 0x40, 0x00),
+		"too many registers":    append(header(hllSparse), 0x7f, 0xff, 0x00),
+		"truncated XZERO":       append(header(hllSparse), 0x7f),
+		"VAL past the end":      append(header(hllSparse), 0x7f, 0xfe, 0x83),
+		"no registers at all":   header(hllSparse),
+		"VAL after full XZEROs": append(header(hllSparse), 0x7f, 0xff, 0x80),
+	}
+	for name, v := range corrupt {
+		if err := CheckHLL(v); err != nil {
+			t.Errorf("%s: CheckHLL = %v, want the header accepted", name, err)
+		}
+		if _, err := HLLCount(v); !errors.Is(err, ErrHLLCorrupt) {
+			t.Errorf("%s: HLLCount = %v, want ErrHLLCorrupt", name, err)
+		}
+		if _, _, err := HLLAdd(v, [][]byte{[]byte("a")}); !errors.Is(err, ErrHLLCorrupt) {
+			t.Errorf("%s: HLLAdd = %v, want ErrHLLCorrupt", name, err)
+		}
+	}
+}
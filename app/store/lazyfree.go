@@ -0,0 +1,96 @@
+package store
+
+import "sync/atomic"
+
+// Values with more elements than this are released by the lazyfree
+// goroutine when unlinked rather than on the command path.
+const lazyfreeThreshold = 64
+
+var (
+	lazyfree        = make(chan any, 1024)
+	lazyfreePending atomic.Int64
+	lazyfreed       atomic.Int64
+)
+
+func init() {
+	go func() {
+		for v := range lazyfree {
+			release(v)
+			lazyfreePending.Add(-1)
+			lazyfreed.Add(1)
+		}
+	}()
+}
+
+// LazyfreePending returns the number of unlinked values not yet released.
+func LazyfreePending() int64 {
+	return lazyfreePending.Load()
+}
+
+// Lazyfreed returns the number of values released in the background.
+func Lazyfreed() int64 {
+	return lazyfreed.Load()
+}
+
+// Unlink removes key like Delete, but big values are handed to a background
+// goroutine to be torn down so the caller does not pay for it.
+func (db *DB) Unlink(key string) bool {
+	v, ok := db.dict.Get(key)
+	if !ok {
+		return false
+	}
+	db.Delete(key)
+	if freeEffort(v) > lazyfreeThreshold {
+		lazyfreePending.Add(1)
+		select {
+		case lazyfree <- v:
+		default:
+			// The queue is full; release it here.
+			release(v)
+			lazyfreePending.Add(-1)
+		}
+	}
+	return true
+}
+
+// lazyfreeDict queues every value of a flushed dict for the lazyfree
+// goroutine.
+func lazyfreeDict(d *Dict[any]) {
+	lazyfreePending.Add(int64(d.Len()))
+	go func() {
+		d.Range(func(_ string, v any) bool {
+			release(v)
+			lazyfreePending.Add(-1)
+			lazyfreed.Add(1)
+			return true
+		})
+	}()
+}
+
+// freeEffort estimates how much work releasing v takes.
+func freeEffort(v any) int {
+	switch v := v.(type) {
+	case *List:
+		return v.Len()
+	case *Hash:
+		return v.Len()
+	case *Stream:
+		return len(v.Entries)
+	default:
+		return 1
+	}
+}
+
+// release drops the internal references of a value that is no longer in the
+// keyspace so the garbage collector can reclaim its parts independently.
+func release(v any) {
+	switch v := v.(type) {
+	case *List:
+		v.release()
+	case *Hash:
+		v.release()
+	case *Stream:
+		clear(v.Entries)
+		v.Entries = nil
+	}
+}
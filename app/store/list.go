@@ -0,0 +1,321 @@
+package store
+
+// listNodeSize is the most elements a list node holds.
+const listNodeSize = 128
+
+// List is the value of a list key. Like the Redis quicklist it is a doubly
+// linked list of nodes holding up to listNodeSize elements each: pushes and
+// pops at either end only touch the end node, indexing skips whole nodes,
+// and a node is released as soon as it empties.
+type List struct {
+	head, tail *listNode
+	length     int
+}
+
+type listNode struct {
+	prev, next *listNode
+	items      []string
+}
+
+func NewList() *List {
+	return &List{}
+}
+
+func (l *List) Len() int {
+	return l.length
+}
+
+func (l *List) PushBack(v string) {
+	if l.tail == nil || len(l.tail.items) >= listNodeSize {
+		l.insertNodeAfter(l.tail, &listNode{items: make([]string, 0, 4)})
+	}
+	l.tail.items = append(l.tail.items, v)
+	l.length++
+}
+
+func (l *List) PushFront(v string) {
+	if l.head == nil || len(l.head.items) >= listNodeSize {
+		l.insertNodeBefore(l.head, &listNode{items: make([]string, 0, 4)})
+	}
+	n := l.head
+	n.items = append(n.items, "")
+	copy(n.items[1:], n.items)
+	n.items[0] = v
+	l.length++
+}
+
+func (l *List) PopFront() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	v := l.head.items[0]
+	l.dropFront(1)
+	return v, true
+}
+
+func (l *List) PopBack() (string, bool) {
+	if l.length == 0 {
+		return "", false
+	}
+	v := l.tail.items[len(l.tail.items)-1]
+	l.dropBack(1)
+	return v, true
+}
+
+// Index returns element i, which must be within the list.
+func (l *List) Index(i int) string {
+	n, off := l.locate(i)
+	return n.items[off]
+}
+
+// SetIndex replaces element i, which must be within the list.
+func (l *List) SetIndex(i int, v string) {
+	n, off := l.locate(i)
+	n.items[off] = v
+}
+
+// Insert inserts v so that it becomes element i, 0 <= i <= Len().
+func (l *List) Insert(i int, v string) {
+	if i == l.length {
+		l.PushBack(v)
+		return
+	}
+	n, off := l.locate(i)
+	if len(n.items) >= listNodeSize {
+		// Split the full node in two and insert into the right half.
+		half := len(n.items) / 2
+		right := &listNode{items: append(make([]string, 0, listNodeSize), n.items[half:]...)}
+		clear(n.items[half:])
+		n.items = n.items[:half]
+		l.insertNodeAfter(n, right)
+		if off >= half {
+			n, off = right, off-half
+		}
+	}
+	n.items = append(n.items, "")
+	copy(n.items[off+1:], n.items[off:])
+	n.items[off] = v
+	l.length++
+}
+
+// Remove removes up to count elements equal to v, scanning from the head,
+// or from the tail when count is negative. A count of 0 removes them all.
+// It returns the number of elements removed.
+func (l *List) Remove(v string, count int) int {
+	removed := 0
+	if count < 0 {
+		for n := l.tail; n != nil && removed < -count; {
+			// Compact towards the end of the node.
+			w := len(n.items)
+			for r := len(n.items) - 1; r >= 0; r-- {
+				if n.items[r] == v && removed < -count {
+					removed++
+					continue
+				}
+				w--
+				n.items[w] = n.items[r]
+			}
+			kept := len(n.items) - w
+			copy(n.items, n.items[w:])
+			clear(n.items[kept:])
+			n.items = n.items[:kept]
+			prev := n.prev
+			if len(n.items) == 0 {
+				l.unlink(n)
+			}
+			n = prev
+		}
+		l.length -= removed
+		return removed
+	}
+
+	for n := l.head; n != nil && (count == 0 || removed < count); {
+		w := 0
+		for _, item := range n.items {
+			if item == v && (count == 0 || removed < count) {
+				removed++
+				continue
+			}
+			n.items[w] = item
+			w++
+		}
+		clear(n.items[w:])
+		n.items = n.items[:w]
+		next := n.next
+		if len(n.items) == 0 {
+			l.unlink(n)
+		}
+		n = next
+	}
+	l.length -= removed
+	return removed
+}
+
+// Trim keeps only the elements from start to end inclusive. Both indexes
+// must already be within the list.
+func (l *List) Trim(start, end int) {
+	l.dropBack(l.length - 1 - end)
+	l.dropFront(start)
+}
+
+// Range returns the elements from start to end inclusive. Both indexes must
+// already be within the list.
+func (l *List) Range(start, end int) []string {
+	res := make([]string, 0, end-start+1)
+	l.Iterate(start, false, func(_ int, v string) bool {
+		res = append(res, v)
+		return len(res) < cap(res)
+	})
+	return res
+}
+
+// Iterate calls fn with each element and its index, from element start
+// towards the tail, or towards the head when reverse is set, until fn
+// returns false. start must be within the list.
+func (l *List) Iterate(start int, reverse bool, fn func(i int, v string) bool) {
+	if l.length == 0 {
+		return
+	}
+	n, off := l.locate(start)
+	i := start
+	for n != nil {
+		if reverse {
+			for ; off >= 0; off-- {
+				if !fn(i, n.items[off]) {
+					return
+				}
+				i--
+			}
+			n = n.prev
+			if n != nil {
+				off = len(n.items) - 1
+			}
+		} else {
+			for ; off < len(n.items); off++ {
+				if !fn(i, n.items[off]) {
+					return
+				}
+				i++
+			}
+			n = n.next
+			off = 0
+		}
+	}
+}
+
+func (l *List) Copy() *List {
+	cp := NewList()
+	for n := l.head; n != nil; n = n.next {
+		cp.insertNodeAfter(cp.tail, &listNode{items: append([]string(nil), n.items...)})
+	}
+	cp.length = l.length
+	return cp
+}
+
+// locate returns the node holding element i and its offset in the node,
+// walking from whichever end is closer.
+func (l *List) locate(i int) (*listNode, int) {
+	if i < l.length/2 {
+		n := l.head
+		for i >= len(n.items) {
+			i -= len(n.items)
+			n = n.next
+		}
+		return n, i
+	}
+	n := l.tail
+	i = l.length - 1 - i
+	for i >= len(n.items) {
+		i -= len(n.items)
+		n = n.prev
+	}
+	return n, len(n.items) - 1 - i
+}
+
+// dropFront removes the first k elements.
+func (l *List) dropFront(k int) {
+	l.length -= k
+	for k > 0 {
+		n := l.head
+		if k >= len(n.items) {
+			k -= len(n.items)
+			l.unlink(n)
+			continue
+		}
+		copy(n.items, n.items[k:])
+		clear(n.items[len(n.items)-k:])
+		n.items = n.items[:len(n.items)-k]
+		k = 0
+	}
+}
+
+// dropBack removes the last k elements.
+func (l *List) dropBack(k int) {
+	l.length -= k
+	for k > 0 {
+		n := l.tail
+		if k >= len(n.items) {
+			k -= len(n.items)
+			l.unlink(n)
+			continue
+		}
+		clear(n.items[len(n.items)-k:])
+		n.items = n.items[:len(n.items)-k]
+		k = 0
+	}
+}
+
+// insertNodeAfter links n after at, or as the head when at is nil.
+func (l *List) insertNodeAfter(at, n *listNode) {
+	if at == nil {
+		n.next = l.head
+		if l.head != nil {
+			l.head.prev = n
+		}
+		l.head = n
+		if l.tail == nil {
+			l.tail = n
+		}
+		return
+	}
+	n.prev, n.next = at, at.next
+	if at.next != nil {
+		at.next.prev = n
+	} else {
+		l.tail = n
+	}
+	at.next = n
+}
+
+// insertNodeBefore links n before at, or as the tail when at is nil.
+func (l *List) insertNodeBefore(at, n *listNode) {
+	if at == nil {
+		l.insertNodeAfter(l.tail, n)
+		return
+	}
+	l.insertNodeAfter(at.prev, n)
+}
+
+func (l *List) unlink(n *listNode) {
+	if n.prev != nil {
+		n.prev.next = n.next
+	} else {
+		l.head = n.next
+	}
+	if n.next != nil {
+		n.next.prev = n.prev
+	} else {
+		l.tail = n.prev
+	}
+	n.prev, n.next, n.items = nil, nil, nil
+}
+
+// release drops every node so they can be collected.
+func (l *List) release() {
+	for n := l.head; n != nil; {
+		next := n.next
+		n.prev, n.next, n.items = nil, nil, nil
+		n = next
+	}
+	l.head, l.tail, l.length = nil, nil, 0
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"strconv"
+	"testing"
+)
+
+// benchListLen is the number of elements every benchmark starts from.
+const benchListLen = 1_000_000
+
+// sliceList is the slice-backed list the quicklist replaced, kept to
+// compare against.
+type sliceList struct {
+	items []string
+}
+
+func (l *sliceList) PushBack(v string) {
+	l.items = append(l.items, v)
+}
+
+func (l *sliceList) PushFront(v string) {
+	l.items = append([]string{v}, l.items...)
+}
+
+func (l *sliceList) PopFront() (string, bool) {
+	if len(l.items) == 0 {
+		return "", false
+	}
+	v := l.items[0]
+	l.items = l.items[1:]
+	return v, true
+}
+
+func (l *sliceList) Index(i int) string {
+	return l.items[i]
+}
+
+func (l *sliceList) Range(start, end int) []string {
+	return append([]string(nil), l.items[start:end+1]...)
+}
+
+func benchValues(n int) []string {
+	vs := make([]string, n)
+	for i := range vs {
+		vs[i] = strconv.Itoa(i)
+	}
+	return vs
+}
+
+func filledList(vs []string) *List {
+	l := NewList()
+	for _, v := range vs {
+		l.PushBack(v)
+	}
+	return l
+}
+
+func filledSliceList(vs []string) *sliceList {
+	l := &sliceList{}
+	for _, v := range vs {
+		l.PushBack(v)
+	}
+	return l
+}
+
+func BenchmarkListPushFront(b *testing.B) {
+	vs := benchValues(benchListLen)
+	b.Run("quicklist", func(b *testing.B) {
+		l := filledList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.PushFront("x")
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		l := filledSliceList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.PushFront("x")
+		}
+	})
+}
+
+func BenchmarkListPopFront(b *testing.B) {
+	vs := benchValues(benchListLen)
+	b.Run("quicklist", func(b *testing.B) {
+		l := filledList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, ok := l.PopFront(); !ok {
+				b.StopTimer()
+				l = filledList(vs)
+				b.StartTimer()
+			}
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		l := filledSliceList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, ok := l.PopFront(); !ok {
+				b.StopTimer()
+				l = filledSliceList(vs)
+				b.StartTimer()
+			}
+		}
+	})
+}
+
+func BenchmarkListIndex(b *testing.B) {
+	vs := benchValues(benchListLen)
+	b.Run("quicklist", func(b *testing.B) {
+		l := filledList(vs)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Index(i * 7919 % benchListLen)
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		l := filledSliceList(vs)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Index(i * 7919 % benchListLen)
+		}
+	})
+}
+
+// BenchmarkListRange reads 100 elements from the middle of the list, the
+// usual shape of an LRANGE page.
+func BenchmarkListRange(b *testing.B) {
+	vs := benchValues(benchListLen)
+	start := benchListLen / 2
+	b.Run("quicklist", func(b *testing.B) {
+		l := filledList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Range(start, start+99)
+		}
+	})
+	b.Run("slice", func(b *testing.B) {
+		l := filledSliceList(vs)
+		b.ReportAllocs()
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			l.Range(start, start+99)
+		}
+	})
+}
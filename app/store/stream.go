@@ -0,0 +1,26 @@
+package store
+
+type StreamEntry struct {
+	ID     string
+	Fields map[string]string
+}
+
+// Stream is the value of a stream key. LastID is the ID of the newest entry
+// ever added, which new IDs must be greater than.
+type Stream struct {
+	Entries []StreamEntry
+	LastID  string
+}
+
+func NewStream() *Stream {
+	return &Stream{}
+}
+
+// Copy returns a copy of the stream. Entries are never modified once added,
+// so they are shared.
+func (s *Stream) Copy() *Stream {
+	return &Stream{
+		Entries: append([]StreamEntry(nil), s.Entries...),
+		LastID:  s.LastID,
+	}
+}
@@ -0,0 +1,123 @@
+package utils
+
+// GlobMatch reports whether s matches the glob-style pattern the way Redis
+// does for KEYS, SCAN MATCH and friends: '*' matches any sequence, '?' any
+// single byte, "[abc]", "[^abc]" and "[a-z]" match classes, and '\' quotes
+// the next byte. Matching is byte oriented, so it is binary safe.
+//
+// It backtracks only to the most recent '*', which bounds the work by
+// len(pattern)*len(s) instead of going exponential on patterns such as
+// "a*a*a*b".
+func GlobMatch(pattern, s []byte, nocase bool) bool {
+	p, i := 0, 0
+	starP, starI := -1, -1
+	for i < len(s) {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				for p < len(pattern) && pattern[p] == '*' {
+					p++
+				}
+				if p == len(pattern) {
+					return true
+				}
+				starP, starI = p, i
+				continue
+			case '?':
+				p++
+				i++
+				continue
+			case '[':
+				if ok, next := matchClass(pattern, p, s[i], nocase); ok {
+					p = next
+					i++
+					continue
+				}
+			case '\\':
+				if p+1 < len(pattern) {
+					if equalByte(pattern[p+1], s[i], nocase) {
+						p += 2
+						i++
+						continue
+					}
+					break
+				}
+				fallthrough
+			default:
+				if equalByte(pattern[p], s[i], nocase) {
+					p++
+					i++
+					continue
+				}
+			}
+		}
+		if starP < 0 {
+			return false
+		}
+		// Let the last '*' swallow one more byte and retry.
+		starI++
+		p, i = starP, starI
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
+
+// matchClass matches c against the class starting at pattern[p] == '['. It
+// returns whether it matched and the index just past the class.
+func matchClass(pattern []byte, p int, c byte, nocase bool) (bool, int) {
+	p++
+	not := p < len(pattern) && pattern[p] == '^'
+	if not {
+		p++
+	}
+	match := false
+	for p < len(pattern) && pattern[p] != ']' {
+		switch {
+		case pattern[p] == '\\' && p+1 < len(pattern):
+			p++
+			if equalByte(pattern[p], c, nocase) {
+				match = true
+			}
+		case p+2 < len(pattern) && pattern[p+1] == '-':
+			// As in Redis, "[a-]" is the range from 'a' to ']', which
+			// leaves the class unterminated.
+			start, end := pattern[p], pattern[p+2]
+			if start > end {
+				start, end = end, start
+			}
+			if nocase {
+				start, end, c = toLower(start), toLower(end), toLower(c)
+			}
+			if c >= start && c <= end {
+				match = true
+			}
+			p += 2
+		default:
+			if equalByte(pattern[p], c, nocase) {
+				match = true
+			}
+		}
+		p++
+	}
+	// Like Redis, an unterminated class runs to the end of the pattern.
+	if p < len(pattern) {
+		p++
+	}
+	return match != not, p
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + 'a' - 'A'
+	}
+	return c
+}
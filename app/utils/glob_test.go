@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"*", "", true},
+		{"*", "anything", true},
+		{"", "", true},
+		{"", "a", false},
+		{"hello", "hello", true},
+		{"hello", "hell", false},
+		{"h?llo", "hello", true},
+		{"h?llo", "hllo", false},
+		{"h*llo", "hllo", true},
+		{"h*llo", "heeeello", true},
+		{"h*llo", "hello!", false},
+		{"*llo", "hello", true},
+		{"he*", "hello", true},
+		{"**a**", "bab", true},
+		{"a*b*c", "aXbYc", true},
+		{"a*b*c", "aXcYb", false},
+		{"user:*:name", "user:42:name", true},
+		{"user:*:name", "user:42:age", false},
+
+		// Classes.
+		{"h[ae]llo", "hello", true},
+		{"h[ae]llo", "hallo", true},
+		{"h[ae]llo", "hillo", false},
+		{"h[^e]llo", "hallo", true},
+		{"h[^e]llo", "hello", false},
+		{"h[a-c]llo", "hbllo", true},
+		{"h[a-c]llo", "hdllo", false},
+		{"h[c-a]llo", "hbllo", true},
+		{"h[0-9a-f]", "h7", true},
+		{"h[0-9a-f]", "hc", true},
+		{"h[0-9a-f]", "hg", false},
+		{"h[^0-9]", "h7", false},
+		{"h[\\]]", "h]", true},
+		{"h[\\^]", "h^", true},
+		{"h[a-]", "h-", false},
+		{"h[a-]", "h_", true},
+		{"[*]", "*", true},
+		{"[*]", "a", false},
+		{"*[ab]", "xxb", true},
+		{"h[abc", "hb", true},
+		{"h[abc", "hd", false},
+
+		// Escapes.
+		{"h\\*llo", "h*llo", true},
+		{"h\\*llo", "hello", false},
+		{"h\\?llo", "h?llo", true},
+		{"h\\?llo", "hello", false},
+		{"\\[a]", "[a]", true},
+		{"\\[a]", "a", false},
+		{"\\\\", "\\", true},
+		{"a\\", "a\\", true},
+		{"\\a", "a", true},
+
+		// Binary safe.
+		{"a?c", "a\x00c", true},
+		{"*\xff", "\x00\xff", true},
+	}
+	for _, tt := range tests {
+		if got := GlobMatch([]byte(tt.pattern), []byte(tt.s), false); got != tt.want {
+			t.Errorf("GlobMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestGlobMatchNocase(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"HELLO", "hello", true},
+		{"h[A-C]llo", "hbllo", true},
+		{"h[a-c]llo", "HBLLO", true},
+		{"h[^B]llo", "hbllo", false},
+		{"h\\Ello", "hello", true},
+		{"h?llo", "HELLO", true},
+		{"h[a-c]llo", "HDLLO", false},
+	}
+	for _, tt := range tests {
+		if got := GlobMatch([]byte(tt.pattern), []byte(tt.s), true); got != tt.want {
+			t.Errorf("GlobMatch(%q, %q, nocase) = %v, want %v", tt.pattern, tt.s, got, tt.want)
+		}
+	}
+}
+
+// A pattern with many stars that fails near the end must not take
+// exponential time.
+func TestGlobMatchPathological(t *testing.T) {
+	pattern := []byte(strings.Repeat("a*", 30) + "b")
+	s := []byte(strings.Repeat("a", 1000))
+	start := time.Now()
+	if GlobMatch(pattern, s, false) {
+		t.Fatal("matched")
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("took %v", d)
+	}
+}
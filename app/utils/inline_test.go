@@ -0,0 +1,58 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSplitInline(t *testing.T) {
+	tests := []struct {
+		line string
+		want [][]byte
+	}{
+		{"PING", args("PING")},
+		{"  SET   k  v  ", args("SET", "k", "v")},
+		{"SET k\tv", args("SET", "k", "v")},
+		{"", nil},
+		{"   ", nil},
+		{`SET k "hello world"`, args("SET", "k", "hello world")},
+		{`SET k ""`, args("SET", "k", "")},
+		{`SET k ''`, args("SET", "k", "")},
+		{`ECHO "a\nb\r\tc\bd\ae"`, args("ECHO", "a\nb\r\tc\bd\ae")},
+		{`ECHO "\x41\x6a\x00"`, args("ECHO", "Aj\x00")},
+		{`ECHO "\xZZ"`, args("ECHO", "xZZ")},
+		{`ECHO "say \"hi\" \\ bye"`, args("ECHO", `say "hi" \ bye`)},
+		{`ECHO 'it\'s'`, args("ECHO", "it's")},
+		{`ECHO 'no \n escapes'`, args("ECHO", `no \n escapes`)},
+		{`ECHO 'a "b" c'`, args("ECHO", `a "b" c`)},
+		{`ECHO "it's"`, args("ECHO", "it's")},
+		{`ECHO a"b c"`, args("ECHO", "ab c")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := splitInline([]byte(tt.line))
+			if err != nil {
+				t.Fatalf("splitInline: %v", err)
+			}
+			if !equalCommands([][][]byte{got}, [][][]byte{tt.want}) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitInlineUnbalancedQuotes(t *testing.T) {
+	for _, line := range []string{
+		`SET k "v`,
+		`SET k 'v`,
+		`SET k "v"x`,
+		`SET k 'v'x`,
+		`SET k "v\"`,
+	} {
+		_, err := splitInline([]byte(line))
+		var perr *ProtocolError
+		if !errors.As(err, &perr) || perr.Error() != "ERR Protocol error: unbalanced quotes in request" {
+			t.Errorf("%s: got error %v, want unbalanced quotes", line, err)
+		}
+	}
+}
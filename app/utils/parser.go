@@ -1,76 +1,59 @@
 package utils
 
 import (
-	"fmt"
+	"errors"
+	"math"
 	"strconv"
-	"strings"
 )
 
-func EncodeAsRESPArray(cmd []string) string {
-	s := fmt.Sprintf("*%d\r\n", len(cmd))
+var ErrNotInteger = errors.New("ERR value is not an integer or out of range")
+
+func EncodeAsRESPArray(cmd [][]byte) []byte {
+	s := []byte("*" + strconv.Itoa(len(cmd)) + "\r\n")
 	for _, arg := range cmd {
-		s += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+		s = append(s, '$')
+		s = strconv.AppendInt(s, int64(len(arg)), 10)
+		s = append(s, "\r\n"...)
+		s = append(s, arg...)
+		s = append(s, "\r\n"...)
 	}
 	return s
 }
 
-func InterfaceSliceToStringSlice(cmd []interface{}) []string {
-	strCmd := make([]string, len(cmd))
-	for i, arg := range cmd {
-		switch v := arg.(type) {
-		case string:
-			strCmd[i] = v
-		case []byte:
-			strCmd[i] = string(v)
-		default:
-			strCmd[i] = fmt.Sprintf("%v", arg) // fallback
-		}
+// ParseInt parses a signed 64-bit decimal argument. Like Redis it rejects
+// leading '+', leading zeros and surrounding spaces so that only the
+// canonical form of a number is accepted.
+func ParseInt(b []byte) (int64, error) {
+	if len(b) == 0 || len(b) > 20 {
+		return 0, ErrNotInteger
 	}
-
-	return strCmd
-}
-
-func TokenizeRESP(raw string) []string {
-
-	clean := strings.ReplaceAll(raw, "\r\n", "\n")
-	lines := strings.Split(clean, "\n")
-	tokens := []string{}
-	for _, line := range lines {
-		if line != "" {
-			tokens = append(tokens, line)
+	digits := b
+	if digits[0] == '-' {
+		digits = digits[1:]
+	}
+	if len(digits) == 0 || (digits[0] == '0' && len(b) != 1) {
+		return 0, ErrNotInteger
+	}
+	for _, c := range digits {
+		if c < '0' || c > '9' {
+			return 0, ErrNotInteger
 		}
 	}
-
-	return tokens
+	n, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil {
+		return 0, ErrNotInteger
+	}
+	return n, nil
 }
 
-func ParseRESP(raw string) []interface{} {
-	lines := TokenizeRESP(raw)
-	cmd := []interface{}{}
-
-	for _, t := range lines {
-		if t == "" {
-			continue
-		}
-
-		switch t[0] {
-		case '*':
-			if len(t) == 1 {
-				cmd = append(cmd, t)
-			}
-		case '$':
-			if len(t) == 1 {
-				cmd = append(cmd, t)
-			}
-		default:
-			if i, err := strconv.Atoi(t); err == nil {
-				cmd = append(cmd, i)
-			} else {
-				cmd = append(cmd, t)
-			}
-		}
+// ParseFloat parses a floating point argument such as a blocking timeout.
+func ParseFloat(b []byte) (float64, error) {
+	if len(b) == 0 || b[0] == ' ' || b[len(b)-1] == ' ' {
+		return 0, errors.New("ERR value is not a valid float")
 	}
-
-	return cmd
-
+	f, err := strconv.ParseFloat(string(b), 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("ERR value is not a valid float")
+	}
+	return f, nil
 }
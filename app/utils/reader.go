@@ -0,0 +1,187 @@
+package utils
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	maxMultibulkLen = 1024 * 1024
+	maxBulkLen      = 512 * 1024 * 1024
+	maxInlineLen    = 64 * 1024
+)
+
+// ProtocolError is returned when the peer sends something that is not valid
+// RESP. The connection should be closed after replying with it.
+type ProtocolError struct {
+	msg string
+}
+
+func (e *ProtocolError) Error() string {
+	return "ERR Protocol error: " + e.msg
+}
+
+// Reader decodes RESP commands from a stream. Partial frames stay buffered
+// until the rest of the frame arrives, so a command may span several TCP
+// segments and a single segment may carry several pipelined commands.
+type Reader struct {
+	rd       *bufio.Reader
+	consumed int64
+}
+
+func NewReader(rd io.Reader) *Reader {
+	return &Reader{rd: bufio.NewReaderSize(rd, 16*1024)}
+}
+
+// Consumed returns the number of bytes decoded so far.
+func (r *Reader) Consumed() int64 {
+	return r.consumed
+}
+
+// ReadAhead buffers whatever the peer sends without consuming it, until the
+// buffer is full or reading fails, and returns the error. It lets a blocked
+// client notice that the connection was closed; no other method may be
+// called until it returns.
+func (r *Reader) ReadAhead() error {
+	for {
+		if _, err := r.rd.Peek(r.rd.Buffered() + 1); err != nil {
+			return err
+		}
+	}
+}
+
+// ReadLine reads a single CRLF terminated line and returns it without the
+// terminator.
+func (r *Reader) ReadLine() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	return string(line), nil
+}
+
+// ReadCommand blocks until a complete command has been received and returns
+// its arguments. Besides the multibulk form it accepts inline commands, a
+// plain line of space separated arguments, as typed into telnet or nc.
+func (r *Reader) ReadCommand() ([][]byte, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '*' {
+			args, err := splitInline(line)
+			if err != nil {
+				return nil, err
+			}
+			if len(args) == 0 {
+				continue
+			}
+			return args, nil
+		}
+
+		n, err := strconv.Atoi(string(line[1:]))
+		if err != nil || n > maxMultibulkLen {
+			return nil, &ProtocolError{"invalid multibulk length"}
+		}
+		if n <= 0 {
+			continue
+		}
+
+		cmd := make([][]byte, 0, n)
+		for i := 0; i < n; i++ {
+			arg, err := r.readBulk()
+			if err != nil {
+				return nil, err
+			}
+			cmd = append(cmd, arg)
+		}
+		return cmd, nil
+	}
+}
+
+// ReadRDB reads the RDB payload a master sends after +FULLRESYNC. Unlike a
+// bulk string it has no trailing CRLF.
+func (r *Reader) ReadRDB() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return nil, &ProtocolError{"expected RDB payload"}
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > maxBulkLen {
+		return nil, &ProtocolError{"invalid RDB length"}
+	}
+	if n < 0 {
+		return nil, nil
+	}
+	payload := make([]byte, n)
+	if _, err := io.ReadFull(r.rd, payload); err != nil {
+		return nil, err
+	}
+	r.consumed += int64(n)
+	return payload, nil
+}
+
+func (r *Reader) readBulk() ([]byte, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		var got byte
+		if len(line) > 0 {
+			got = line[0]
+		}
+		return nil, &ProtocolError{fmt.Sprintf("expected '$', got '%c'", got)}
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n < 0 || n > maxBulkLen {
+		return nil, &ProtocolError{"invalid bulk length"}
+	}
+
+	buf := make([]byte, n+2)
+	if _, err := io.ReadFull(r.rd, buf); err != nil {
+		return nil, err
+	}
+	r.consumed += int64(n + 2)
+	if buf[n] != '\r' || buf[n+1] != '\n' {
+		return nil, &ProtocolError{"bulk string not terminated by CRLF"}
+	}
+	return buf[:n], nil
+}
+
+// readLine returns the next line without its CR LF or bare LF terminator.
+// The returned slice is only valid until the next read.
+func (r *Reader) readLine() ([]byte, error) {
+	line, err := r.rd.ReadSlice('\n')
+	if errors.Is(err, bufio.ErrBufferFull) {
+		// Only inline commands have lines longer than the buffer.
+		long := append([]byte(nil), line...)
+		for errors.Is(err, bufio.ErrBufferFull) && len(long) <= maxInlineLen {
+			line, err = r.rd.ReadSlice('\n')
+			long = append(long, line...)
+		}
+		if len(long) > maxInlineLen {
+			return nil, &ProtocolError{"too big inline request"}
+		}
+		line = long
+	}
+	if err != nil {
+		return nil, err
+	}
+	r.consumed += int64(len(line))
+	line = line[:len(line)-1]
+	if len(line) > 0 && line[len(line)-1] == '\r' {
+		line = line[:len(line)-1]
+	}
+	return line, nil
+}
@@ -0,0 +1,201 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+// chunkReader returns the input a few bytes at a time, the way a command can
+// arrive split across TCP segments.
+type chunkReader struct {
+	data []byte
+	size int
+}
+
+func (r *chunkReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := min(len(p), r.size, len(r.data))
+	copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// readAll reads commands until the input runs out.
+func readAll(r *Reader) ([][][]byte, error) {
+	var cmds [][][]byte
+	for {
+		cmd, err := r.ReadCommand()
+		if err == io.EOF {
+			return cmds, nil
+		}
+		if err != nil {
+			return cmds, err
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func args(s ...string) [][]byte {
+	b := make([][]byte, len(s))
+	for i := range s {
+		b[i] = []byte(s[i])
+	}
+	return b
+}
+
+func TestReadCommand(t *testing.T) {
+	big := strings.Repeat("x", 100*1024)
+	tests := []struct {
+		name  string
+		input string
+		want  [][][]byte
+	}{
+		{
+			name:  "single",
+			input: "*2\r\n$4\r\nECHO\r\n$5\r\nhello\r\n",
+			want:  [][][]byte{args("ECHO", "hello")},
+		},
+		{
+			name:  "pipelined",
+			input: "*1\r\n$4\r\nPING\r\n*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$1\r\nv\r\n*2\r\n$3\r\nGET\r\n$1\r\nk\r\n",
+			want:  [][][]byte{args("PING"), args("SET", "k", "v"), args("GET", "k")},
+		},
+		{
+			name:  "binary safe",
+			input: "*2\r\n$4\r\nECHO\r\n$6\r\na\r\nb\x00c\r\n",
+			want:  [][][]byte{args("ECHO", "a\r\nb\x00c")},
+		},
+		{
+			name:  "empty argument",
+			input: "*2\r\n$4\r\nECHO\r\n$0\r\n\r\n",
+			want:  [][][]byte{args("ECHO", "")},
+		},
+		{
+			name:  "empty multibulk skipped",
+			input: "*0\r\n*-1\r\n\r\n*1\r\n$4\r\nPING\r\n",
+			want:  [][][]byte{args("PING")},
+		},
+		{
+			name:  "larger than the buffer",
+			input: "*3\r\n$3\r\nSET\r\n$1\r\nk\r\n$102400\r\n" + big + "\r\n*1\r\n$4\r\nPING\r\n",
+			want:  [][][]byte{args("SET", "k", big), args("PING")},
+		},
+		{
+			name:  "inline after multibulk",
+			input: "*1\r\n$4\r\nPING\r\nSET k v\r\nGET k\n",
+			want:  [][][]byte{args("PING"), args("SET", "k", "v"), args("GET", "k")},
+		},
+	}
+	readers := []struct {
+		name string
+		wrap func(string) io.Reader
+	}{
+		{"whole", func(s string) io.Reader { return strings.NewReader(s) }},
+		{"one byte", func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) }},
+		{"chunks of 7", func(s string) io.Reader { return &chunkReader{[]byte(s), 7} }},
+		{"chunks of 4096", func(s string) io.Reader { return &chunkReader{[]byte(s), 4096} }},
+	}
+	for _, tt := range tests {
+		for _, rd := range readers {
+			t.Run(tt.name+"/"+rd.name, func(t *testing.T) {
+				r := NewReader(rd.wrap(tt.input))
+				got, err := readAll(r)
+				if err != nil {
+					t.Fatalf("ReadCommand: %v", err)
+				}
+				if !equalCommands(got, tt.want) {
+					t.Errorf("got %q, want %q", got, tt.want)
+				}
+				if r.Consumed() != int64(len(tt.input)) {
+					t.Errorf("Consumed() = %d, want %d", r.Consumed(), len(tt.input))
+				}
+			})
+		}
+	}
+}
+
+func TestReadCommandProtocolError(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"multibulk length not a number", "*x\r\n", "ERR Protocol error: invalid multibulk length"},
+		{"multibulk too long", "*1048577\r\n", "ERR Protocol error: invalid multibulk length"},
+		{"argument not a bulk", "*1\r\n:1\r\n", "ERR Protocol error: expected '$', got ':'"},
+		{"negative bulk length", "*1\r\n$-1\r\n", "ERR Protocol error: invalid bulk length"},
+		{"bulk too long", "*1\r\n$536870913\r\n", "ERR Protocol error: invalid bulk length"},
+		{"bulk not terminated", "*1\r\n$4\r\nPINGxx", "ERR Protocol error: bulk string not terminated by CRLF"},
+		{"inline too long", strings.Repeat("a", maxInlineLen+1) + "\r\n", "ERR Protocol error: too big inline request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(strings.NewReader(tt.input)).ReadCommand()
+			var perr *ProtocolError
+			if !errors.As(err, &perr) {
+				t.Fatalf("got error %v, want a ProtocolError", err)
+			}
+			if perr.Error() != tt.want {
+				t.Errorf("got %q, want %q", perr.Error(), tt.want)
+			}
+		})
+	}
+}
+
+func TestReadCommandTruncated(t *testing.T) {
+	for _, input := range []string{"*2\r\n$4\r\nECHO\r\n", "*1\r\n$4\r\nPI", "*1\r\n$4"} {
+		_, err := NewReader(strings.NewReader(input)).ReadCommand()
+		if err == nil {
+			t.Errorf("%q: got no error", input)
+		}
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			t.Errorf("%q: got protocol error %v for a frame cut short", input, err)
+		}
+	}
+}
+
+func TestReadRDB(t *testing.T) {
+	payload := "REDIS0011\xfa\x00\xff"
+	input := "+FULLRESYNC id 0\r\n$" + strconv.Itoa(len(payload)) + "\r\n" + payload + "*1\r\n$4\r\nPING\r\n"
+	r := NewReader(iotest.OneByteReader(strings.NewReader(input)))
+	if line, err := r.ReadLine(); err != nil || line != "+FULLRESYNC id 0" {
+		t.Fatalf("ReadLine() = %q, %v", line, err)
+	}
+	rdb, err := r.ReadRDB()
+	if err != nil || !bytes.Equal(rdb, []byte(payload)) {
+		t.Fatalf("ReadRDB() = %q, %v, want %q", rdb, err, payload)
+	}
+	base := r.Consumed()
+	cmd, err := r.ReadCommand()
+	if err != nil || !equalCommands([][][]byte{cmd}, [][][]byte{args("PING")}) {
+		t.Fatalf("ReadCommand() after RDB = %q, %v", cmd, err)
+	}
+	if got := r.Consumed() - base; got != 14 {
+		t.Errorf("PING consumed %d bytes, want 14", got)
+	}
+}
+
+func equalCommands(a, b [][][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if len(a[i]) != len(b[i]) {
+			return false
+		}
+		for j := range a[i] {
+			if !bytes.Equal(a[i][j], b[i][j]) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,265 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// Writer encodes replies for a single connection. It speaks RESP2 by default
+// and switches to RESP3 once the client negotiates it with HELLO; the RESP3
+// only types fall back to their closest RESP2 equivalent.
+//
+// Replies are built in memory and only reach the connection when the
+// connection loop calls Flush, after the command has run and the keyspace
+// lock is released: a client that stops reading its socket must not stall
+// the handler, and every other client with it.
+type Writer struct {
+	w      bytes.Buffer
+	out    io.Writer
+	proto  int
+	buf    []byte
+	errors int
+}
+
+// maxRetainedReply is the largest reply buffer kept for the next command;
+// the memory of larger ones is given back once they have been sent.
+const maxRetainedReply = 64 * 1024
+
+func NewWriter(out io.Writer) *Writer {
+	return &Writer{out: out, proto: 2}
+}
+
+// Flush sends the buffered replies to the connection. It must not be called
+// with the keyspace locked.
+func (w *Writer) Flush() error {
+	_, err := w.out.Write(w.w.Bytes())
+	if w.w.Cap() > maxRetainedReply {
+		w.w = bytes.Buffer{}
+	} else {
+		w.w.Reset()
+	}
+	return err
+}
+
+// Errors returns the number of error replies written so far.
+func (w *Writer) Errors() int {
+	return w.errors
+}
+
+func (w *Writer) Proto() int {
+	return w.proto
+}
+
+func (w *Writer) SetProto(proto int) {
+	w.proto = proto
+}
+
+// WriteSimpleString writes a status reply. Newlines in s are replaced with
+// spaces, so that text echoed from a client can't end the line early.
+func (w *Writer) WriteSimpleString(s string) {
+	w.buf = append(w.buf[:0], '+')
+	w.buf = appendLine(w.buf, s)
+	w.writeCRLF()
+}
+
+// WriteError writes an error reply. msg must start with the error code, e.g.
+// "ERR syntax error" or "WRONGTYPE ...". Newlines are replaced with spaces,
+// as for WriteSimpleString.
+func (w *Writer) WriteError(msg string) {
+	w.errors++
+	w.buf = append(w.buf[:0], '-')
+	w.buf = appendLine(w.buf, msg)
+	w.writeCRLF()
+}
+
+// appendLine appends s to b with every '\r' and '\n' turned into a space.
+func appendLine(b []byte, s string) []byte {
+	start := len(b)
+	b = append(b, s...)
+	for i := start; i < len(b); i++ {
+		if b[i] == '\r' || b[i] == '\n' {
+			b[i] = ' '
+		}
+	}
+	return b
+}
+
+func (w *Writer) WriteInt(n int64) {
+	w.writeHeader(':', n)
+}
+
+func (w *Writer) WriteBulk(b []byte) {
+	w.writeHeader('$', int64(len(b)))
+	w.w.Write(b)
+	w.w.WriteString("\r\n")
+}
+
+func (w *Writer) WriteBulkString(s string) {
+	w.WriteBulk([]byte(s))
+}
+
+// WriteNull writes a null bulk string, the reply for a missing value.
+func (w *Writer) WriteNull() {
+	if w.proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("$-1\r\n")
+}
+
+// WriteNullArray writes a null multibulk, the reply for a timed out blocking
+// command.
+func (w *Writer) WriteNullArray() {
+	if w.proto >= 3 {
+		w.w.WriteString("_\r\n")
+		return
+	}
+	w.w.WriteString("*-1\r\n")
+}
+
+func (w *Writer) WriteArrayLen(n int) {
+	w.writeHeader('*', int64(n))
+}
+
+// WriteMapLen starts a map of n key/value pairs. Under RESP2 it is sent as a
+// flat array of 2*n elements.
+func (w *Writer) WriteMapLen(n int) {
+	if w.proto >= 3 {
+		w.writeHeader('%', int64(n))
+		return
+	}
+	w.writeHeader('*', int64(2*n))
+}
+
+func (w *Writer) WriteSetLen(n int) {
+	if w.proto >= 3 {
+		w.writeHeader('~', int64(n))
+		return
+	}
+	w.writeHeader('*', int64(n))
+}
+
+// WritePushLen starts an out of band push message such as a pub/sub
+// delivery.
+func (w *Writer) WritePushLen(n int) {
+	if w.proto >= 3 {
+		w.writeHeader('>', int64(n))
+		return
+	}
+	w.writeHeader('*', int64(n))
+}
+
+// WriteDouble writes a double reply. Under RESP2 it is sent as a bulk string.
+func (w *Writer) WriteDouble(f float64) {
+	var s string
+	switch {
+	case math.IsInf(f, 1):
+		s = "inf"
+	case math.IsInf(f, -1):
+		s = "-inf"
+	default:
+		s = strconv.FormatFloat(f, 'g', 17, 64)
+	}
+	if w.proto >= 3 {
+		w.buf = append(w.buf[:0], ',')
+		w.buf = append(w.buf, s...)
+		w.writeCRLF()
+		return
+	}
+	w.WriteBulkString(s)
+}
+
+// WriteBool writes a boolean reply. Under RESP2 it is sent as 1 or 0.
+func (w *Writer) WriteBool(b bool) {
+	if w.proto >= 3 {
+		if b {
+			w.w.WriteString("#t\r\n")
+		} else {
+			w.w.WriteString("#f\r\n")
+		}
+		return
+	}
+	if b {
+		w.WriteInt(1)
+	} else {
+		w.WriteInt(0)
+	}
+}
+
+// WriteVerbatim writes free-form text such as the INFO output. format is the
+// three letter RESP3 type hint ("txt" or "mkd").
+func (w *Writer) WriteVerbatim(format, s string) {
+	if w.proto >= 3 {
+		w.writeHeader('=', int64(len(s)+4))
+		w.w.WriteString(format + ":" + s + "\r\n")
+		return
+	}
+	w.WriteBulkString(s)
+}
+
+// WriteRDB sends an RDB snapshot to a replica. It is framed like a bulk
+// string but without the trailing CRLF.
+func (w *Writer) WriteRDB(payload []byte) {
+	w.writeHeader('$', int64(len(payload)))
+	w.w.Write(payload)
+}
+
+// WriteBulkArray writes an array of bulk strings.
+func (w *Writer) WriteBulkArray(items [][]byte) {
+	w.WriteArrayLen(len(items))
+	for _, item := range items {
+		w.WriteBulk(item)
+	}
+}
+
+// WriteValue writes an arbitrarily nested reply built from Go values:
+// nil, strings and byte slices, integers, floats, bools, errors and slices
+// of any of these.
+func (w *Writer) WriteValue(v any) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteNull()
+	case []byte:
+		w.WriteBulk(v)
+	case string:
+		w.WriteBulkString(v)
+	case int:
+		w.WriteInt(int64(v))
+	case int64:
+		w.WriteInt(v)
+	case float64:
+		w.WriteDouble(v)
+	case bool:
+		w.WriteBool(v)
+	case error:
+		w.WriteError(v.Error())
+	case [][]byte:
+		w.WriteBulkArray(v)
+	case []string:
+		w.WriteArrayLen(len(v))
+		for _, item := range v {
+			w.WriteBulkString(item)
+		}
+	case []any:
+		w.WriteArrayLen(len(v))
+		for _, item := range v {
+			w.WriteValue(item)
+		}
+	default:
+		panic(fmt.Sprintf("utils: cannot encode %T as a reply", v))
+	}
+}
+
+func (w *Writer) writeHeader(prefix byte, n int64) {
+	w.buf = append(w.buf[:0], prefix)
+	w.buf = strconv.AppendInt(w.buf, n, 10)
+	w.writeCRLF()
+}
+
+func (w *Writer) writeCRLF() {
+	w.buf = append(w.buf, '\r', '\n')
+	w.w.Write(w.buf)
+}
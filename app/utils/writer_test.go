@@ -0,0 +1,70 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestWriterLineReplies(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(w *Writer)
+		want  string
+	}{
+		{"status", func(w *Writer) { w.WriteSimpleString("OK") }, "+OK\r\n"},
+		{"error", func(w *Writer) { w.WriteError("ERR syntax error") }, "-ERR syntax error\r\n"},
+		{
+			"status with newlines",
+			func(w *Writer) { w.WriteSimpleString("a\r\n+OK\nb\rc") },
+			"+a  +OK b c\r\n",
+		},
+		{
+			"error echoing a client argument",
+			func(w *Writer) { w.WriteError("ERR unknown command 'a\r\n+OK'") },
+			"-ERR unknown command 'a  +OK'\r\n",
+		},
+		{
+			"bulk strings are left alone",
+			func(w *Writer) { w.WriteBulkString("a\r\nb") },
+			"$4\r\na\r\nb\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := NewWriter(&out)
+			tt.write(w)
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.want {
+				t.Errorf("got %q, want %q", out.String(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWriterFlush(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.WriteArrayLen(2)
+	w.WriteBulkString(string(make([]byte, 2*maxRetainedReply)))
+	w.WriteInt(1)
+	if out.Len() != 0 {
+		t.Fatalf("%d bytes written before Flush", out.Len())
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	want := len("*2\r\n$131072\r\n") + 2*maxRetainedReply + len("\r\n:1\r\n")
+	if out.Len() != want {
+		t.Fatalf("Flush wrote %d bytes, want %d", out.Len(), want)
+	}
+
+	out.Reset()
+	w.WriteSimpleString("PONG")
+	w.Flush()
+	if out.String() != "+PONG\r\n" {
+		t.Errorf("second reply %q", out.String())
+	}
+}